@@ -0,0 +1,119 @@
+// Просмотр и повторная отправка сообщений из dead-letter топика.
+//
+//	dlq                                 - вывести все сообщения (JSON, по одному в строке)
+//	dlq -replay -partition 0 -offset 42 - отправить сообщение обратно в исходный топик
+//	dlq -replay                         - отправить обратно все сообщения
+//
+// Параметры подключения к Kafka - те же переменные окружения, что и у контроллера
+package main
+
+import (
+	"TController/internal/messageBroker"
+	"TController/internal/model"
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/caarlos0/env"
+	"go.uber.org/zap"
+)
+
+type Params = struct {
+	BrokerURL         []string `env:"BROKER_URL" envSeparator:"," envDefault:"10.101.15.110:9094"`
+	RegistryURL       string   `env:"REGISTRY_URL" envDefault:"http://10.101.15.110:8081"`
+	BrokerUser        string   `env:"BROKER_USER" envDefault:""`
+	BrokerPass        string   `env:"BROKER_PASS" envDefault:""`
+	BrokerPassFile    string   `env:"BROKER_PASS_FILE" envDefault:""`
+	BrokerSASL        string   `env:"BROKER_SASL_MECHANISM" envDefault:""`
+	BrokerTLS         bool     `env:"BROKER_TLS" envDefault:"false"`
+	BrokerCAFile      string   `env:"BROKER_CA_FILE" envDefault:""`
+	BrokerCertFile    string   `env:"BROKER_CERT_FILE" envDefault:""`
+	BrokerKeyFile     string   `env:"BROKER_KEY_FILE" envDefault:""`
+	BrokerTLSNoVerify bool     `env:"BROKER_TLS_SKIP_VERIFY" envDefault:"false"`
+	InSchemeID        int      `env:"IN_SCHEME" envDefault:"92"`
+	OutSchemeID       int      `env:"OUT_SCHEME" envDefault:"71"`
+	InSubject         string   `env:"IN_SUBJECT" envDefault:"b2b-TT_IN-value"`
+	OutSubject        string   `env:"OUT_SUBJECT" envDefault:"b2b-TT_OUT-value"`
+	BrokerGroupID     string   `env:"BROKER_GROUP" envDefault:"TicketSystemController"`
+	DLQTopic          string   `env:"DLQ_TOPIC" envDefault:"b2b-TT_OUT-DLQ"`
+}
+
+func main() {
+	var params Params
+	err := env.Parse(&params)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	topic := flag.String("topic", params.DLQTopic, "dead-letter topic")
+	replay := flag.Bool("replay", false, "send selected messages back to their original topic")
+	partition := flag.Int("partition", -1, "dead-letter partition, -1 - all")
+	offset := flag.Int64("offset", -1, "dead-letter offset, -1 - all")
+	flag.Parse()
+	if *topic == "" {
+		log.Fatalln("dead-letter topic is not set: use -topic or DLQ_TOPIC")
+	}
+
+	lg := zap.NewExample()
+	defer lg.Sync()
+	broker := messageBroker.NewKafkaBroker()
+	err = broker.InitBroker(messageBroker.ConnectionConfig{
+		Brokers:       params.BrokerURL,
+		TLS:           params.BrokerTLS,
+		CAFile:        params.BrokerCAFile,
+		CertFile:      params.BrokerCertFile,
+		KeyFile:       params.BrokerKeyFile,
+		SkipVerify:    params.BrokerTLSNoVerify,
+		SASLMechanism: params.BrokerSASL,
+		User:          params.BrokerUser,
+		Password:      params.BrokerPass,
+		PasswordFile:  params.BrokerPassFile,
+	},
+		make(chan *model.Message),
+		messageBroker.SchemaConfig{
+			RegistryURL: params.RegistryURL,
+			InID:        uint32(params.InSchemeID),
+			InSubject:   params.InSubject,
+			OutID:       uint32(params.OutSchemeID),
+			OutSubject:  params.OutSubject,
+		},
+		params.BrokerGroupID,
+		*topic,
+		messageBroker.WriterConfig{}, lg)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	ctx := context.Background()
+	letters, err := broker.ReadDeadLetters(ctx, *topic)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	for i := range letters {
+		letter := &letters[i]
+		if *partition >= 0 && letter.Partition != *partition {
+			continue
+		}
+		if *offset >= 0 && letter.Offset != *offset {
+			continue
+		}
+		if !*replay {
+			err = encoder.Encode(letter)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			continue
+		}
+		err = broker.Replay(ctx, letter)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		log.Printf("replayed %d/%d to %s", letter.Partition, letter.Offset, letter.OriginalTopic)
+	}
+	err = broker.Close()
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
@@ -9,13 +9,20 @@ import (
 	"TController/internal/cache"
 	"TController/internal/messageBroker"
 	"TController/internal/model"
+	"TController/internal/outbox"
 	"TController/internal/responseController"
+	"TController/internal/routing"
+	"TController/internal/sla"
+	"TController/internal/sources"
+	"TController/internal/statuses"
 	"TController/internal/ticketer"
 	"context"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/caarlos0/env"
 	"github.com/go-chi/chi/v5"
@@ -28,26 +35,72 @@ type Params = struct {
 	Host string `env:"TTS_HOST" envDefault:"0.0.0.0"`
 
 	//Kafka
-	BrokerURL string `env:"BROKER_URL" envDefault:"10.101.15.110:9094"`
+	BrokerURL []string `env:"BROKER_URL" envSeparator:"," envDefault:"10.101.15.110:9094"` //список брокеров через запятую
 	//BrokerURL string `env:"BROKER_URL" envDefault:"esb-3.ertelecom.ru:9094"`
 	OutTopic string `env:"OUT_TOPIC" envDefault:"b2b-TT_OUT"`
 	InTopic  string `env:"IN_TOPIC" envDefault:"b2b-TT_IN"`
 	//InTopic         string `env:"IN_TOPIC" envDefault:"b2b-TT_OUT" //для тестирования ответо`
-	RegistryURL     string `env:"REGISTRY_URL" envDefault:"http://10.101.15.110:8081/schemas/ids/"`
+	RegistryURL     string `env:"REGISTRY_URL" envDefault:"http://10.101.15.110:8081"`
 	BrokerUser      string `env:"BROKER_USER" envDefault:""`
 	BrokerPass      string `env:"BROKER_PASS" envDefault:""`
-	InSchemeID      int    `env:"IN_SCHEME" envDefault:"92"`
+	InSchemeID      int    `env:"IN_SCHEME" envDefault:"92"` //ID схемы avro, 0 - последняя версия subject
 	OutSchemeID     int    `env:"OUT_SCHEME" envDefault:"71"`
+	InSubject       string `env:"IN_SUBJECT" envDefault:"b2b-TT_IN-value"`
+	OutSubject      string `env:"OUT_SUBJECT" envDefault:"b2b-TT_OUT-value"`
+	InSchemaFile    string `env:"IN_SCHEMA_FILE" envDefault:""` //регистрируется в IN_SUBJECT
 	BrokerGroupID   string `env:"BROKER_GROUP" envDefault:"TicketSystemController"`
 	ConsumerStreams int    `env:"CONSUMER_STREAMS" envDefault:"5"`
+	DLQTopic        string `env:"DLQ_TOPIC" envDefault:"b2b-TT_OUT-DLQ"` //обязателен: сообщения, которые не удалось разобрать
+	//Отправка: пачка до BATCH_SIZE сообщений или BATCH_TIMEOUT, очередь асинхронной отправки на топик
+	BrokerBatchSize    int           `env:"BROKER_BATCH_SIZE" envDefault:"100"`
+	BrokerBatchTimeout time.Duration `env:"BROKER_BATCH_TIMEOUT" envDefault:"10ms"`
+	BrokerQueueSize    int           `env:"BROKER_QUEUE_SIZE" envDefault:"1000"`
+	ShutdownTimeout    time.Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"30s"`
+	//Формат сообщений топиков: список topic=format (avro, json, protobuf), по умолчанию avro
+	TopicFormats []string `env:"TOPIC_FORMATS" envSeparator:"," envDefault:""`
+	//TLS и SASL, пароль - из BROKER_PASS или файла BROKER_PASS_FILE
+	BrokerPassFile    string `env:"BROKER_PASS_FILE" envDefault:""`
+	BrokerSASL        string `env:"BROKER_SASL_MECHANISM" envDefault:""` //NONE, PLAIN, SCRAM-SHA-256, SCRAM-SHA-512
+	BrokerTLS         bool   `env:"BROKER_TLS" envDefault:"false"`
+	BrokerCAFile      string `env:"BROKER_CA_FILE" envDefault:""`
+	BrokerCertFile    string `env:"BROKER_CERT_FILE" envDefault:""`
+	BrokerKeyFile     string `env:"BROKER_KEY_FILE" envDefault:""`
+	BrokerTLSNoVerify bool   `env:"BROKER_TLS_SKIP_VERIFY" envDefault:"false"`
 
-	//Redis
+	//Redis, memory:// - кэш в памяти процесса
 	CacheDSN string `env:"CACHE_DSN" envDefault:"redis://@dev-redis-master/0"`
 	CacheTTL int64  `env:"CACHE_TTL" envDefault:"259200"` //3 дня
+	DedupTTL int64  `env:"DEDUP_TTL" envDefault:"86400"`  //время хранения ключей обработанных ответов, 1 день
 
-	//SberAPI
-	SberAPIID  string `env:"SBER_API_URI" envDefault:"sberapi"`
+	//Timer
+	TimerTimeout  time.Duration `env:"TIMER_TIMEOUT" envDefault:"30m"` //время ожидания ответа от системы
+	TimerInterval time.Duration `env:"TIMER_INTERVAL" envDefault:"1m"` //период проверки кэша
+
+	//SLA, политика по умолчанию, 0 - срок не контролируется
+	SLAPolicyFile      string        `env:"SLA_POLICY_FILE" envDefault:""`
+	SLAFirstResponse   time.Duration `env:"SLA_FIRST_RESPONSE" envDefault:"0"`
+	SLAResolution      time.Duration `env:"SLA_RESOLUTION" envDefault:"0"`
+	SLAEscalationTopic string        `env:"SLA_ESCALATION_TOPIC" envDefault:""`
+
+	//Outbox, доставка событий в источники
+	OutboxInterval    time.Duration `env:"OUTBOX_INTERVAL" envDefault:"5s"`
+	OutboxTimeout     time.Duration `env:"OUTBOX_TIMEOUT" envDefault:"10s"`
+	OutboxMaxAttempts int           `env:"OUTBOX_MAX_ATTEMPTS" envDefault:"10"`
+	OutboxBackoff     time.Duration `env:"OUTBOX_BACKOFF" envDefault:"10s"`
+	OutboxMaxBackoff  time.Duration `env:"OUTBOX_MAX_BACKOFF" envDefault:"30m"`
+	OutboxBatchSize   int           `env:"OUTBOX_BATCH_SIZE" envDefault:"20"`
+
+	//Источники запросов: файл с описанием и/или список name=callback_url
+	SourcesFile string   `env:"SOURCES_FILE" envDefault:""`
+	Sources     []string `env:"SOURCES" envSeparator:"," envDefault:"sberapi"`
+	//Устаревший адрес источника sberapi: используется, если адрес не задан в SOURCES или SOURCES_FILE
 	SberAPIURI string `env:"SBER_API_URI" envDefault:""`
+
+	//Таблица маршрутизации запросов по системам, пустой - правила по умолчанию (KRUS/RIAS)
+	RoutingFile string `env:"ROUTING_FILE" envDefault:""`
+
+	//Матрица трансляции статусов систем и источников, пустой - статусы контроллера без трансляции
+	StatusMatrixFile string `env:"STATUS_MATRIX_FILE" envDefault:""`
 }
 
 func main() {
@@ -69,49 +122,135 @@ func execute(controllerParameters Params) (err error) {
 	lg := zap.NewExample()
 	defer lg.Sync()
 
-	cachePool := cache.InitCache(controllerParameters.CacheDSN)
-	cache := cache.NewRedisCache(cachePool, controllerParameters.CacheTTL, lg)
+	cache := cache.NewCache(controllerParameters.CacheDSN, controllerParameters.CacheTTL, lg)
+	//Записи, сохраненные до перехода на ключи по TicketID
+	migrated, err := cache.Migrate(context.Background())
+	if err != nil {
+		return err
+	}
+	lg.Info("Cache migrated", zap.Int("records", migrated))
 	cacheController := v1.NewCacheController(cache, lg)
 
-	out := make(chan *model.Ticket)
+	//Остановка по SIGINT/SIGTERM: чтение из kafka и таймеры прекращаются, накопленные сообщения отправляются
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	out := make(chan *model.Message)
 	broker := messageBroker.NewKafkaBroker()
-	broker.InitBroker(controllerParameters.BrokerURL,
+	err = broker.InitBroker(messageBroker.ConnectionConfig{
+		Brokers:       controllerParameters.BrokerURL,
+		TLS:           controllerParameters.BrokerTLS,
+		CAFile:        controllerParameters.BrokerCAFile,
+		CertFile:      controllerParameters.BrokerCertFile,
+		KeyFile:       controllerParameters.BrokerKeyFile,
+		SkipVerify:    controllerParameters.BrokerTLSNoVerify,
+		SASLMechanism: controllerParameters.BrokerSASL,
+		User:          controllerParameters.BrokerUser,
+		Password:      controllerParameters.BrokerPass,
+		PasswordFile:  controllerParameters.BrokerPassFile,
+	},
 		out,
-		uint32(controllerParameters.InSchemeID),
-		uint32(controllerParameters.OutSchemeID),
+		messageBroker.SchemaConfig{
+			RegistryURL:  controllerParameters.RegistryURL,
+			InID:         uint32(controllerParameters.InSchemeID),
+			InSubject:    controllerParameters.InSubject,
+			InSchemaFile: controllerParameters.InSchemaFile,
+			OutID:        uint32(controllerParameters.OutSchemeID),
+			OutSubject:   controllerParameters.OutSubject,
+			Formats:      controllerParameters.TopicFormats,
+		},
 		controllerParameters.BrokerGroupID,
-		controllerParameters.BrokerUser,
-		controllerParameters.BrokerPass,
-		controllerParameters.RegistryURL, lg)
-
-	go func() {
-		broker.Consumer(context.Background(), controllerParameters.OutTopic)
-	}()
+		controllerParameters.DLQTopic,
+		messageBroker.WriterConfig{
+			BatchSize:    controllerParameters.BrokerBatchSize,
+			BatchTimeout: controllerParameters.BrokerBatchTimeout,
+			QueueSize:    controllerParameters.BrokerQueueSize,
+		}, lg)
+	if err != nil {
+		return err
+	}
 
-	//ctx := context.Background()
-	ctx := context.TODO()
-	timer := timer2.NewTimer(ctx, time.Minute*2, cache)
 	go func() {
-		for {
-			timer.FindExpired()
-			log.Println("Cache checked for expired records")
-			time.Sleep(time.Minute)
-		}
+		broker.Consumer(ctx, controllerParameters.OutTopic)
 	}()
 
 	ticketWorker := ticketer.NewTicketWorker(broker, controllerParameters.InTopic)
-	ticketController := v1.NewTicketer(ticketWorker, cache, lg)
+	registry, err := sources.Load(controllerParameters.SourcesFile, controllerParameters.Sources,
+		map[string]string{"sberapi": controllerParameters.SberAPIURI})
+	if err != nil {
+		return err
+	}
+	translator, err := statuses.LoadMatrix(controllerParameters.StatusMatrixFile)
+	if err != nil {
+		return err
+	}
+	statusesController := v1.NewStatusesController(translator, lg)
+	routes, err := routing.LoadRules(controllerParameters.RoutingFile)
+	if err != nil {
+		return err
+	}
+	ticketController := v1.NewTicketer(ticketWorker, cache, registry, translator, routes, lg)
+
+	webhooks := outbox.NewOutbox(ctx,
+		outbox.NewStore(controllerParameters.CacheDSN, lg),
+		registry,
+		outbox.Config{
+			Interval:    controllerParameters.OutboxInterval,
+			Timeout:     controllerParameters.OutboxTimeout,
+			MaxAttempts: controllerParameters.OutboxMaxAttempts,
+			Backoff:     controllerParameters.OutboxBackoff,
+			MaxBackoff:  controllerParameters.OutboxMaxBackoff,
+			BatchSize:   controllerParameters.OutboxBatchSize,
+		}, lg)
+	go webhooks.Run()
+	outboxController := v1.NewOutboxController(webhooks, lg)
 
-	receiver := responseController.NewReceiver(out, cache, ticketWorker, lg)
+	receiver := responseController.NewReceiver(ctx, out, cache, ticketWorker, webhooks, registry, translator, routes,
+		controllerParameters.DedupTTL, lg)
 	receiver.InitReceiversPull(controllerParameters.ConsumerStreams)
-	receiver.AddSource(controllerParameters.SberAPIID, controllerParameters.SberAPIURI)
 
-	router := httpserver.NewRouter(chi.NewRouter(), lg, ticketController, cacheController)
+	policies, err := sla.LoadPolicies(controllerParameters.SLAPolicyFile, sla.Policy{
+		Name:            "default",
+		Acknowledgement: model.Duration(controllerParameters.TimerTimeout),
+		FirstResponse:   model.Duration(controllerParameters.SLAFirstResponse),
+		Resolution:      model.Duration(controllerParameters.SLAResolution),
+	})
+	if err != nil {
+		return err
+	}
+	timer := timer2.NewTimer(ctx,
+		controllerParameters.TimerInterval,
+		controllerParameters.TimerTimeout,
+		policies,
+		cache,
+		receiver,
+		broker,
+		controllerParameters.SLAEscalationTopic,
+		lg)
+	go timer.Run()
+
+	router := httpserver.NewRouter(chi.NewRouter(), lg, ticketController, cacheController, outboxController, statusesController)
 	server := http.Server{
 		Addr:        net.JoinHostPort(controllerParameters.Host, controllerParameters.Port),
 		Handler:     &router,
 		IdleTimeout: time.Second * 30,
 	}
 
-	return server.ListenAndServe()
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+	select {
+	case err = <-serverErr:
+	case <-ctx.Done():
+		lg.Info("Shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), controllerParameters.ShutdownTimeout)
+		defer cancel()
+		err = server.Shutdown(shutdownCtx)
+	}
+	closeErr := broker.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
 }
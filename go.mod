@@ -0,0 +1,24 @@
+module TController
+
+go 1.17
+
+require (
+	github.com/caarlos0/env v3.5.0+incompatible
+	github.com/go-chi/chi/v5 v5.0.7
+	github.com/gomodule/redigo v1.8.8
+	github.com/linkedin/goavro v2.1.0+incompatible
+	github.com/segmentio/kafka-go v0.4.25
+	go.uber.org/zap v1.20.0
+)
+
+require (
+	github.com/golang/snappy v0.0.1 // indirect
+	github.com/klauspost/compress v1.9.8 // indirect
+	github.com/pierrec/lz4 v2.6.0+incompatible // indirect
+	github.com/xdg/scram v0.0.0-20180814205039-7eeb5667e42c // indirect
+	github.com/xdg/stringprep v1.0.0 // indirect
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
+	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550 // indirect
+	golang.org/x/text v0.3.3 // indirect
+)
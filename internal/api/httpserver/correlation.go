@@ -0,0 +1,21 @@
+package httpserver
+
+import (
+	"TController/internal/cache"
+	"TController/internal/model"
+	"TController/pkg/webhook"
+	"net/http"
+)
+
+// Correlation ID запроса источника из заголовка X-Correlation-ID, без заголовка - новый.
+// Возвращается в ответе и передается в систему в заголовке сообщения брокера
+func correlation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		correlationID := request.Header.Get(webhook.HeaderCorrelationID)
+		if correlationID == "" {
+			correlationID = cache.NewTicketID()
+		}
+		writer.Header().Set(webhook.HeaderCorrelationID, correlationID)
+		next.ServeHTTP(writer, request.WithContext(model.WithCorrelationID(request.Context(), correlationID)))
+	})
+}
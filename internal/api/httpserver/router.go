@@ -11,11 +11,15 @@ import (
 func NewRouter(mux *chi.Mux,
 	lg *zap.Logger,
 	ticketController *v1.Ticket,
-	cacheController *v1.CacheController) chi.Mux {
+	cacheController *v1.CacheController,
+	outboxController *v1.OutboxController,
+	statusesController *v1.StatusesController) chi.Mux {
 	mux.Use(middleware.Logger)
+	mux.Use(correlation)
 	mux.Route("/api/v1", func(router chi.Router) {
 		ticketRouter(router, ticketController)
 		cacheRouter(router, cacheController)
+		adminRouter(router, outboxController, statusesController)
 	})
 	lg.Info("Router is started")
 	return *mux
@@ -28,6 +32,7 @@ func ticketRouter(router chi.Router, ticketController *v1.Ticket) chi.Router {
 	router.Post("/checkticketstatus", ticketController.CheckTicketStatus)
 	router.Post("/addnotetoticket", ticketController.AddNoteToTicket)
 	router.Post("/closeticket", ticketController.CloseTicket)
+	router.Get("/tickets/{id}/history", ticketController.GetHistory)
 
 	return router
 }
@@ -36,3 +41,12 @@ func cacheRouter(router chi.Router, cacheController *v1.CacheController) chi.Rou
 	router.Post("/cache/checkticketstatus", cacheController.CheckStatus)
 	return router
 }
+
+func adminRouter(router chi.Router,
+	outboxController *v1.OutboxController,
+	statusesController *v1.StatusesController) chi.Router {
+	router.Get("/admin/outbox/dead", outboxController.GetDeadLetters)
+	router.Post("/admin/outbox/dead/{id}/redrive", outboxController.Redrive)
+	router.Get("/admin/statuses/unmapped", statusesController.GetUnmapped)
+	return router
+}
@@ -4,6 +4,7 @@ import (
 	"TController/internal/cache"
 	"TController/internal/model"
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"go.uber.org/zap"
@@ -26,13 +27,24 @@ func (c *CacheController) CheckStatus(writer http.ResponseWriter, request *http.
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
-	data.Status, err = c.cache.GetStatusFromCache(request.Context(), data.CustomerInternalID)
+	cacheRecord, err := resolveTicket(request.Context(), c.cache, data)
+	if errors.Is(err, cache.ErrNotFound) {
+		c.lg.Error("CheckStatus", zap.Error(err))
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.lg.Error("CheckStatus", zap.Error(err))
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	data.Status = string(cacheRecord.Status)
+	writer.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(writer).Encode(&data)
 	if err != nil {
 		c.lg.Error("CheckStatus", zap.Error(err))
 		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
-	writer.Header().Set("Content-Type", "application/json")
 	return
 }
@@ -0,0 +1,61 @@
+package v1
+
+import (
+	"TController/internal/outbox"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+type OutboxController struct {
+	outbox outbox.Outbox
+	lg     *zap.Logger
+}
+
+func NewOutboxController(outbox outbox.Outbox, lg *zap.Logger) *OutboxController {
+	return &OutboxController{outbox: outbox, lg: lg}
+}
+
+// Недоставленные в источники события
+func (o *OutboxController) GetDeadLetters(writer http.ResponseWriter, request *http.Request) {
+	events, err := o.outbox.GetDeadLetters(request.Context())
+	if err != nil {
+		o.lg.Error("GetDeadLetters", zap.Error(err))
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(writer).Encode(events)
+	if err != nil {
+		o.lg.Error("GetDeadLetters", zap.Error(err))
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	return
+}
+
+// Повторная отправка события из dead-letter
+func (o *OutboxController) Redrive(writer http.ResponseWriter, request *http.Request) {
+	event, err := o.outbox.Redrive(request.Context(), chi.URLParam(request, "id"))
+	if errors.Is(err, outbox.ErrNotFound) {
+		o.lg.Error("Redrive", zap.Error(err))
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		o.lg.Error("Redrive", zap.Error(err))
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(writer).Encode(event)
+	if err != nil {
+		o.lg.Error("Redrive", zap.Error(err))
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	return
+}
@@ -0,0 +1,30 @@
+package v1
+
+import (
+	"TController/internal/statuses"
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+type StatusesController struct {
+	statuses statuses.Translator
+	lg       *zap.Logger
+}
+
+func NewStatusesController(statuses statuses.Translator, lg *zap.Logger) *StatusesController {
+	return &StatusesController{statuses: statuses, lg: lg}
+}
+
+// Статусы, которых нет в матрице трансляции
+func (s *StatusesController) GetUnmapped(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(writer).Encode(s.statuses.GetUnmapped())
+	if err != nil {
+		s.lg.Error("GetUnmapped", zap.Error(err))
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	return
+}
@@ -3,13 +3,19 @@ package v1
 import (
 	"TController/internal/cache"
 	"TController/internal/model"
+	"TController/internal/routing"
+	"TController/internal/sources"
+	"TController/internal/statuses"
 	"TController/internal/ticketer"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 )
 
@@ -19,15 +25,24 @@ var ErrIDChannelOperatorEmpty = errors.New("IDChannelOperator is empty")
 var ErrDescriptionEmpty = errors.New("Description is empty")
 var ErrTTStartTimeEmpty = errors.New("TTStartTime is empty")
 var ErrOperatorTTIdEmpty = errors.New("OperatorTTId is empty")
+var ErrTicketIDEmpty = errors.New("TicketID, CustomerInternalID and OperatorTTId are empty")
 
 type Ticket struct {
 	ticketer ticketer.Ticket
 	cache    cache.Cache
+	sources  sources.Registry
+	statuses statuses.Translator
+	routes   routing.Router
 	lg       *zap.Logger
 }
 
-func NewTicketer(ticketer ticketer.Ticket, cache cache.Cache, lg *zap.Logger) *Ticket {
-	return &Ticket{ticketer: ticketer, cache: cache, lg: lg}
+func NewTicketer(ticketer ticketer.Ticket,
+	cache cache.Cache,
+	registry sources.Registry,
+	translator statuses.Translator,
+	routes routing.Router,
+	lg *zap.Logger) *Ticket {
+	return &Ticket{ticketer: ticketer, cache: cache, sources: registry, statuses: translator, routes: routes, lg: lg}
 }
 
 func (t *Ticket) CreateTicket(writer http.ResponseWriter, request *http.Request) {
@@ -38,12 +53,24 @@ func (t *Ticket) CreateTicket(writer http.ResponseWriter, request *http.Request)
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
-	billingID, err := t.ticketer.IDChannelConverter(data.IDChannelOperator)
+	_, err = t.sources.Check(data.Source, data.MessageType)
+	if errors.Is(err, sources.ErrUnknownSource) {
+		t.lg.Error("CreateTicket", zap.Error(err))
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		t.lg.Error("CreateTicket", zap.Error(err))
+		http.Error(writer, err.Error(), http.StatusForbidden)
+		return
+	}
+	targets, err := t.routes.Route(data.IDChannelOperator, data.TTClassification, data.Source)
 	if err != nil {
 		t.lg.Error("CreateTicket", zap.Error(err))
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
+	billingID := targets[0]
 	ticket := t.makeTicket(data, data.MessageType, billingID)
 	err = t.CheckInFields(data.MessageType, ticket)
 	if err != nil {
@@ -51,7 +78,14 @@ func (t *Ticket) CreateTicket(writer http.ResponseWriter, request *http.Request)
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
+	status, err := model.Transition("", model.ToSystem, data.MessageType)
+	if err != nil {
+		t.lg.Error("CreateTicket", zap.Error(err))
+		http.Error(writer, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return
+	}
 	cacheRecord := cache.CacheRecord{
+		TicketID:                    cache.NewTicketID(),
 		Source:                      data.Source,
 		CustomerInternalID:          data.CustomerInternalID,
 		IDChannelOperatorForBilling: billingID,
@@ -63,21 +97,30 @@ func (t *Ticket) CreateTicket(writer http.ResponseWriter, request *http.Request)
 		OperatorTTId:                data.OperatorTTId,
 		FileName:                    data.FileName,
 		File:                        data.File,
-		Status:                      model.Creating,
-		Created:                     time.Now().String(),
-		Modified:                    time.Now().String(),
+		Status:                      status,
+		Candidates:                  strings.Join(targets, ","),
+		Created:                     cache.Timestamp(time.Now()),
+		Modified:                    cache.Timestamp(time.Now()),
 	}
 	err = t.cache.WriteToCache(request.Context(), &cacheRecord)
 	if err != nil {
 		t.lg.Error("CreateTicket", zap.Error(err))
 	}
-	err = t.ticketer.CreateTicket(request.Context(), ticket)
+	err = t.ticketer.CreateTicket(model.WithSource(request.Context(), data.Source), ticket)
 	if err != nil {
 		t.lg.Error("CreateTicket", zap.Error(err))
 		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
+	t.recordEvent(request.Context(), cacheRecord.TicketID, ticket)
+	//Источник получает TicketID для последующих запросов по заявке
+	data.TicketID = cacheRecord.TicketID
+	data.Status = string(cacheRecord.Status)
 	writer.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(writer).Encode(&data)
+	if err != nil {
+		t.lg.Error("CreateTicket", zap.Error(err))
+	}
 	return
 }
 
@@ -89,34 +132,37 @@ func (t *Ticket) ReopenTicket(writer http.ResponseWriter, request *http.Request)
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
-	billingID, err := t.cache.GetProcessingSystemFromCache(request.Context(), data.CustomerInternalID)
+	cacheRecord, err := resolveTicket(request.Context(), t.cache, data)
+	if errors.Is(err, cache.ErrNotFound) {
+		t.lg.Error("ReopenTicket", zap.Error(err))
+		http.Error(writer, model.ErrReopenExpired.Error(), http.StatusGone)
+		return
+	}
 	if err != nil {
 		t.lg.Error("ReopenTicket", zap.Error(err))
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
-	ticket := t.makeTicket(data, data.MessageType, billingID)
+	ticket := t.makeTicket(data, data.MessageType, cacheRecord.IDChannelOperatorForBilling)
 	err = t.CheckInFields(data.MessageType, ticket)
 	if err != nil {
 		t.lg.Error("ReopenTicket", zap.Error(err))
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
-	cacheRecord := cache.CacheRecord{
-		CustomerInternalID: data.CustomerInternalID,
-		Status:             model.Working,
-		Modified:           time.Now().String(),
-	}
-	err = t.cache.WriteToCache(request.Context(), &cacheRecord)
+	status, err := model.Transition(cacheRecord.Status, model.ToSystem, data.MessageType)
 	if err != nil {
 		t.lg.Error("ReopenTicket", zap.Error(err))
+		http.Error(writer, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return
 	}
-	err = t.ticketer.ReopenTicket(request.Context(), ticket)
+	err = t.ticketer.ReopenTicket(model.WithSource(request.Context(), cacheRecord.Source), ticket)
 	if err != nil {
 		t.lg.Error("ReopenTicket", zap.Error(err))
 		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
+	t.saveTransition(request.Context(), cacheRecord.TicketID, status, ticket)
 	writer.Header().Set("Content-Type", "application/json")
 	return
 }
@@ -129,25 +175,41 @@ func (t *Ticket) ChangeTicketStatus(writer http.ResponseWriter, request *http.Re
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
-	billingID, err := t.cache.GetProcessingSystemFromCache(request.Context(), data.CustomerInternalID)
+	cacheRecord, err := resolveTicket(request.Context(), t.cache, data)
 	if err != nil {
 		t.lg.Error("ChangeTicketStatus", zap.Error(err))
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
-	ticket := t.makeTicket(data, data.MessageType, billingID)
+	ticket := t.makeTicket(data, data.MessageType, cacheRecord.IDChannelOperatorForBilling)
+	//Источник передает статус контроллера, в систему уходит ее собственный
+	if data.Status != "" {
+		ticket.TTStatus, err = t.statuses.ToSystem(ticket.IDChannelOperatorForBilling, model.TTStatus(data.Status))
+		if err != nil {
+			t.lg.Error("ChangeTicketStatus", zap.Error(err))
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 	err = t.CheckInFields(data.MessageType, ticket)
 	if err != nil {
 		t.lg.Error("ChangeTicketStatus", zap.Error(err))
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
-	err = t.ticketer.ChangeTicketStatus(request.Context(), ticket)
+	status, err := model.Transition(cacheRecord.Status, model.ToSystem, data.MessageType)
+	if err != nil {
+		t.lg.Error("ChangeTicketStatus", zap.Error(err))
+		http.Error(writer, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return
+	}
+	err = t.ticketer.ChangeTicketStatus(model.WithSource(request.Context(), cacheRecord.Source), ticket)
 	if err != nil {
 		t.lg.Error("ChangeTicketStatus", zap.Error(err))
 		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
+	t.saveTransition(request.Context(), cacheRecord.TicketID, status, ticket)
 	writer.Header().Set("Content-Type", "application/json")
 	return
 }
@@ -159,25 +221,32 @@ func (t *Ticket) CheckTicketStatus(writer http.ResponseWriter, request *http.Req
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
-	billingID, err := t.cache.GetProcessingSystemFromCache(request.Context(), data.CustomerInternalID)
+	cacheRecord, err := resolveTicket(request.Context(), t.cache, data)
 	if err != nil {
 		t.lg.Error("CheckTicketStatus", zap.Error(err))
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
-	ticket := t.makeTicket(data, data.MessageType, billingID)
+	ticket := t.makeTicket(data, data.MessageType, cacheRecord.IDChannelOperatorForBilling)
 	err = t.CheckInFields(data.MessageType, ticket)
 	if err != nil {
 		t.lg.Error("CheckTicketStatus", zap.Error(err))
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
-	err = t.ticketer.CheckTicketStatus(request.Context(), ticket)
+	status, err := model.Transition(cacheRecord.Status, model.ToSystem, data.MessageType)
+	if err != nil {
+		t.lg.Error("CheckTicketStatus", zap.Error(err))
+		http.Error(writer, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return
+	}
+	err = t.ticketer.CheckTicketStatus(model.WithSource(request.Context(), cacheRecord.Source), ticket)
 	if err != nil {
 		t.lg.Error("CheckTicketStatus", zap.Error(err))
 		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
+	t.saveTransition(request.Context(), cacheRecord.TicketID, status, ticket)
 	writer.Header().Set("Content-Type", "application/json")
 	return
 }
@@ -189,33 +258,32 @@ func (t *Ticket) AddNoteToTicket(writer http.ResponseWriter, request *http.Reque
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
-	billingID, err := t.cache.GetProcessingSystemFromCache(request.Context(), data.CustomerInternalID)
+	cacheRecord, err := resolveTicket(request.Context(), t.cache, data)
 	if err != nil {
 		t.lg.Error("AddNoteToTicket", zap.Error(err))
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
-	ticket := t.makeTicket(data, data.MessageType, billingID)
+	ticket := t.makeTicket(data, data.MessageType, cacheRecord.IDChannelOperatorForBilling)
 	err = t.CheckInFields(data.MessageType, ticket)
 	if err != nil {
 		t.lg.Error("AddNoteToTicket", zap.Error(err))
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
-	cacheRecord := cache.CacheRecord{
-		CustomerInternalID: data.CustomerInternalID,
-		Modified:           time.Now().String(),
-	}
-	err = t.cache.WriteToCache(request.Context(), &cacheRecord)
+	status, err := model.Transition(cacheRecord.Status, model.ToSystem, data.MessageType)
 	if err != nil {
 		t.lg.Error("AddNoteToTicket", zap.Error(err))
+		http.Error(writer, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return
 	}
-	err = t.ticketer.AddNoteToTicket(request.Context(), ticket)
+	err = t.ticketer.AddNoteToTicket(model.WithSource(request.Context(), cacheRecord.Source), ticket)
 	if err != nil {
 		t.lg.Error("AddNoteToTicket", zap.Error(err))
 		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
+	t.saveTransition(request.Context(), cacheRecord.TicketID, status, ticket)
 	writer.Header().Set("Content-Type", "application/json")
 	return
 }
@@ -227,39 +295,76 @@ func (t *Ticket) CloseTicket(writer http.ResponseWriter, request *http.Request)
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
-	billingID, err := t.cache.GetProcessingSystemFromCache(request.Context(), data.CustomerInternalID)
+	cacheRecord, err := resolveTicket(request.Context(), t.cache, data)
 	if err != nil {
 		t.lg.Error("CloseTicket", zap.Error(err))
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
-	ticket := t.makeTicket(data, data.MessageType, billingID)
+	ticket := t.makeTicket(data, data.MessageType, cacheRecord.IDChannelOperatorForBilling)
 	err = t.CheckInFields(data.MessageType, ticket)
 	if err != nil {
 		t.lg.Error("CloseTicket", zap.Error(err))
 		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
-	cacheRecord := cache.CacheRecord{
-		CustomerInternalID: data.CustomerInternalID,
-		Status:             model.Closed,
-		Modified:           time.Now().String(),
-	}
-	err = t.cache.WriteToCache(request.Context(), &cacheRecord)
+	status, err := model.Transition(cacheRecord.Status, model.ToSystem, data.MessageType)
 	if err != nil {
 		t.lg.Error("CloseTicket", zap.Error(err))
+		http.Error(writer, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return
 	}
-	err = t.ticketer.CloseTicket(request.Context(), ticket)
+	err = t.ticketer.CloseTicket(model.WithSource(request.Context(), cacheRecord.Source), ticket)
 	if err != nil {
 		t.lg.Error("CloseTicket", zap.Error(err))
 		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
+	t.saveTransition(request.Context(), cacheRecord.TicketID, status, ticket)
 	writer.Header().Set("Content-Type", "application/json")
 	return
 
 }
 
+// Статус меняется только после успешной отправки запроса в систему
+func (t *Ticket) saveTransition(ctx context.Context, ticketID string, status model.TTStatus, ticket *model.Ticket) {
+	err := t.cache.UpdateCache(ctx, &cache.CacheRecord{
+		TicketID: ticketID,
+		Status:   status,
+	})
+	if err != nil {
+		t.lg.Error("saveTransition", zap.Error(err))
+	}
+	t.recordEvent(ctx, ticketID, ticket)
+}
+
+func (t *Ticket) recordEvent(ctx context.Context, ticketID string, ticket *model.Ticket) {
+	err := t.cache.AppendHistory(ctx, model.NewTicketEvent(ticketID, model.ToSystem, ticket))
+	if err != nil {
+		t.lg.Error("recordEvent", zap.Error(err))
+	}
+}
+
+func (t *Ticket) GetHistory(writer http.ResponseWriter, request *http.Request) {
+	ticketID := chi.URLParam(request, "id")
+	events, err := t.cache.GetHistory(request.Context(), ticketID)
+	if err != nil {
+		t.lg.Error("GetHistory", zap.Error(err))
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if len(events) == 0 {
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(writer).Encode(events)
+	if err != nil {
+		t.lg.Error("GetHistory", zap.Error(err))
+	}
+	return
+}
+
 func (t *Ticket) CheckInFields(method model.RequestType, data *model.Ticket) error {
 	switch method {
 	case model.Create:
@@ -342,3 +447,62 @@ func (t *Ticket) makeTicket(data *model.TicketDTO, messageType model.RequestType
 
 	return &ticket
 }
+
+// Запрос ищется по TicketID, без него - среди запросов клиента по номеру в системе (tt_number),
+// без клиента - по номеру в системе.
+// Незаполненные в запросе поля, нужные системе, берутся из кэша
+func resolveTicket(ctx context.Context, c cache.Cache, data *model.TicketDTO) (*cache.CacheRecord, error) {
+	var record *cache.CacheRecord
+	var err error
+	switch {
+	case data.TicketID != "":
+		record, err = c.GetFromCacheByTicketID(ctx, data.TicketID)
+		if err != nil {
+			return record, fmt.Errorf("resolveTicket: %w", err)
+		}
+		if record.TicketID == "" {
+			return record, fmt.Errorf("resolveTicket: %w", cache.ErrNotFound)
+		}
+	case data.CustomerInternalID != "":
+		records, err := c.GetTicketsByCustomerID(ctx, data.CustomerInternalID)
+		if err != nil {
+			return &cache.CacheRecord{}, fmt.Errorf("resolveTicket: %w", err)
+		}
+		matched := make([]*cache.CacheRecord, 0)
+		for _, r := range records {
+			if data.OperatorTTId != "" && r.OperatorTTId != data.OperatorTTId {
+				continue
+			}
+			matched = append(matched, r)
+		}
+		switch len(matched) {
+		case 0:
+			return &cache.CacheRecord{}, fmt.Errorf("resolveTicket: %w", cache.ErrNotFound)
+		case 1:
+			record = matched[0]
+		default:
+			return &cache.CacheRecord{}, fmt.Errorf("resolveTicket: %w", cache.ErrAmbiguous)
+		}
+	case data.OperatorTTId != "":
+		record, err = c.GetFromCacheByOperatorTTId(ctx, data.OperatorTTId)
+		if err != nil {
+			return record, fmt.Errorf("resolveTicket: %w", err)
+		}
+		if record.TicketID == "" {
+			return record, fmt.Errorf("resolveTicket: %w", cache.ErrNotFound)
+		}
+	default:
+		return &cache.CacheRecord{}, fmt.Errorf("resolveTicket: %w", ErrTicketIDEmpty)
+	}
+	data.TicketID = record.TicketID
+	if data.CustomerInternalID == "" {
+		data.CustomerInternalID = record.CustomerInternalID
+	}
+	if data.IDChannelOperator == "" {
+		data.IDChannelOperator = record.IDChannelOperator
+	}
+	if data.OperatorTTId == "" {
+		data.OperatorTTId = record.OperatorTTId
+	}
+	return record, nil
+}
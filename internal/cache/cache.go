@@ -1,17 +1,76 @@
 package cache
 
 import (
+	"TController/internal/model"
 	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
 )
 
+var ErrNotFound = errors.New("record not found")
+var ErrTicketIDEmpty = errors.New("TicketID is empty")
+var ErrAmbiguous = errors.New("more than one record found")
+var ErrConflict = errors.New("record changed concurrently")
+
 type Cache interface {
 	WriteToCache(ctx context.Context, ticket *CacheRecord) error
 	DeleteFromCache(ctx context.Context, ticket *CacheRecord) error
+	//Обновляет только заполненные поля существующей записи и время изменения (текущее, если Modified не задано)
 	UpdateCache(ctx context.Context, ticket *CacheRecord) error
 	GetFromCacheByKey(ctx context.Context, key string) (*CacheRecord, error)
+	GetFromCacheByTicketID(ctx context.Context, ticketID string) (*CacheRecord, error)
+	//Единственный запрос клиента, если запросов несколько - ErrAmbiguous
 	GetFromCacheByCustomerID(ctx context.Context, customerInternalID string) (*CacheRecord, error)
-	GetStatusFromCache(ctx context.Context, customerInternalID string) (string, error)
-	GetSourceFromCache(ctx context.Context, customerInternalID string) (string, error)
-	GetProcessingSystemFromCache(ctx context.Context, customerInternalID string) (string, error)
+	GetTicketsByCustomerID(ctx context.Context, customerInternalID string) ([]*CacheRecord, error)
+	//Поиск по номеру запроса в системе (tt_erth), индекс обновляется при сохранении OperatorTTId
+	GetFromCacheByOperatorTTId(ctx context.Context, operatorTTId string) (*CacheRecord, error)
+	GetTicketsByIDChannelOperator(ctx context.Context, idChannelOperator string) ([]*CacheRecord, error)
+	GetStatusFromCache(ctx context.Context, ticketID string) (string, error)
+	GetSourceFromCache(ctx context.Context, ticketID string) (string, error)
+	GetProcessingSystemFromCache(ctx context.Context, ticketID string) (string, error)
 	GetAllKeysFromCache(ctx context.Context) ([]string, error)
+	//Журнал событий по запросу, хранится с тем же TTL, что и запись
+	AppendHistory(ctx context.Context, event *model.TicketEvent) error
+	GetHistory(ctx context.Context, ticketID string) ([]model.TicketEvent, error)
+	//Ключи обработанных сообщений брокера, хранятся ttl секунд
+	IsSeen(ctx context.Context, key string) (bool, error)
+	MarkSeen(ctx context.Context, key string, ttl int64) error
+	//Перевод записей со старыми ключами CustomerInternalID:<id> на ключи по TicketID
+	Migrate(ctx context.Context) (int, error)
+}
+
+// memory:// - кэш в памяти процесса (тесты, запуск на одном узле), иначе DSN Redis
+func NewCache(dsn string, ttl int64, lg *zap.Logger) Cache {
+	if strings.HasPrefix(dsn, "memory://") {
+		return NewMemoryCache(ttl, lg)
+	}
+	return NewRedisCache(InitCache(dsn), ttl, lg)
+}
+
+// Ключ записи Ticket:<TicketID>, вторичные индексы ссылаются на TicketID
+func ticketKey(ticketID string) string {
+	return fmt.Sprintf("Ticket:%s", ticketID)
+}
+
+func historyKey(ticketID string) string {
+	return fmt.Sprintf("History:%s", ticketID)
+}
+
+func seenKey(key string) string {
+	return fmt.Sprintf("Seen:%s", key)
+}
+
+func customerIndexKey(customerInternalID string) string {
+	return fmt.Sprintf("Index:CustomerInternalID:%s", customerInternalID)
+}
+
+func channelIndexKey(idChannelOperator string) string {
+	return fmt.Sprintf("Index:IDChannelOperator:%s", idChannelOperator)
+}
+
+func operatorTTIdIndexKey(operatorTTId string) string {
+	return fmt.Sprintf("Index:OperatorTTId:%s", operatorTTId)
 }
@@ -0,0 +1,290 @@
+package cache
+
+import (
+	"TController/internal/model"
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Реализация Cache для общих тестов, expire - истечение TTL всех записей
+type backend struct {
+	cache  Cache
+	expire func()
+}
+
+func TestMemoryConformance(t *testing.T) {
+	testConformance(t, func(t *testing.T) backend {
+		m := NewMemoryCache(60, zap.NewNop()).(*memoryCache)
+		return backend{
+			cache: m,
+			expire: func() {
+				m.now = func() time.Time { return time.Now().Add(61 * time.Second) }
+			},
+		}
+	})
+}
+
+// TEST_REDIS_DSN - отдельная база Redis, например redis://localhost:6379/15: перед каждым тестом она очищается
+func TestRedisConformance(t *testing.T) {
+	dsn := os.Getenv("TEST_REDIS_DSN")
+	if dsn == "" {
+		t.Skip("TEST_REDIS_DSN is not set")
+	}
+	testConformance(t, func(t *testing.T) backend {
+		pool := InitCache(dsn)
+		t.Cleanup(func() { pool.Close() })
+		conn := pool.Get()
+		defer conn.Close()
+		_, err := conn.Do("FLUSHDB")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return backend{
+			cache: NewRedisCache(pool, 1, zap.NewNop()),
+			expire: func() {
+				time.Sleep(2100 * time.Millisecond)
+			},
+		}
+	})
+}
+
+func testConformance(t *testing.T, newBackend func(t *testing.T) backend) {
+	ctx := context.Background()
+	first := &CacheRecord{
+		TicketID:           "A",
+		Source:             "sber",
+		CustomerInternalID: "c1",
+		IDChannelOperator:  "ch1",
+		OperatorTTId:       "T1",
+		Status:             model.Creating,
+		Description:        "first",
+		Created:            "100",
+		Modified:           "100",
+	}
+	second := &CacheRecord{
+		TicketID:           "B",
+		Source:             "sber",
+		CustomerInternalID: "c1",
+		IDChannelOperator:  "ch2",
+		Status:             model.Working,
+		Created:            "200",
+		Modified:           "200",
+	}
+	write := func(t *testing.T, c Cache, records ...*CacheRecord) {
+		t.Helper()
+		for _, record := range records {
+			copied := *record
+			err := c.WriteToCache(ctx, &copied)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	ticketIDs := func(records []*CacheRecord) []string {
+		ids := make([]string, len(records))
+		for i, record := range records {
+			ids[i] = record.TicketID
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, b backend)
+	}{
+		{name: "write and read", run: func(t *testing.T, b backend) {
+			write(t, b.cache, first)
+			got, err := b.cache.GetFromCacheByTicketID(ctx, "A")
+			if err != nil || !reflect.DeepEqual(got, first) {
+				t.Fatalf("record = %+v, %v", got, err)
+			}
+			status, err := b.cache.GetStatusFromCache(ctx, "A")
+			if err != nil || status != string(model.Creating) {
+				t.Fatalf("status = %q, %v", status, err)
+			}
+			source, err := b.cache.GetSourceFromCache(ctx, "A")
+			if err != nil || source != "sber" {
+				t.Fatalf("source = %q, %v", source, err)
+			}
+		}},
+		{name: "missing record", run: func(t *testing.T, b backend) {
+			got, err := b.cache.GetFromCacheByTicketID(ctx, "missing")
+			if err != nil || got.TicketID != "" {
+				t.Fatalf("record = %+v, %v", got, err)
+			}
+			_, err = b.cache.GetStatusFromCache(ctx, "missing")
+			if !errors.Is(err, ErrNotFound) {
+				t.Fatalf("GetStatusFromCache err = %v", err)
+			}
+			err = b.cache.UpdateCache(ctx, &CacheRecord{TicketID: "missing", Status: model.Working})
+			if !errors.Is(err, ErrNotFound) {
+				t.Fatalf("UpdateCache err = %v", err)
+			}
+		}},
+		{name: "empty ticket id", run: func(t *testing.T, b backend) {
+			errs := []error{
+				b.cache.WriteToCache(ctx, &CacheRecord{}),
+				b.cache.UpdateCache(ctx, &CacheRecord{}),
+				b.cache.DeleteFromCache(ctx, &CacheRecord{}),
+				b.cache.AppendHistory(ctx, &model.TicketEvent{}),
+			}
+			for i, err := range errs {
+				if !errors.Is(err, ErrTicketIDEmpty) {
+					t.Fatalf("call %d: err = %v", i, err)
+				}
+			}
+		}},
+		{name: "update merges set fields", run: func(t *testing.T, b backend) {
+			write(t, b.cache, first, second)
+			err := b.cache.UpdateCache(ctx, &CacheRecord{TicketID: "A", Status: model.Working})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = b.cache.UpdateCache(ctx, &CacheRecord{TicketID: "B", Status: model.Waiting, Modified: "250"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			a, _ := b.cache.GetFromCacheByTicketID(ctx, "A")
+			if a.Status != model.Working || a.Description != "first" || a.Modified == "100" || a.Modified == "" {
+				t.Fatalf("A = %+v", a)
+			}
+			b2, _ := b.cache.GetFromCacheByTicketID(ctx, "B")
+			if b2.Status != model.Waiting || b2.Modified != "250" {
+				t.Fatalf("B = %+v", b2)
+			}
+		}},
+		{name: "indexes follow updates", run: func(t *testing.T, b backend) {
+			write(t, b.cache, first)
+			err := b.cache.UpdateCache(ctx, &CacheRecord{TicketID: "A", CustomerInternalID: "c2", IDChannelOperator: "ch3", OperatorTTId: "T2"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			old, _ := b.cache.GetTicketsByCustomerID(ctx, "c1")
+			current, _ := b.cache.GetTicketsByCustomerID(ctx, "c2")
+			if len(old) != 0 || !reflect.DeepEqual(ticketIDs(current), []string{"A"}) {
+				t.Fatalf("by customer: c1 %v, c2 %v", ticketIDs(old), ticketIDs(current))
+			}
+			old, _ = b.cache.GetTicketsByIDChannelOperator(ctx, "ch1")
+			current, _ = b.cache.GetTicketsByIDChannelOperator(ctx, "ch3")
+			if len(old) != 0 || !reflect.DeepEqual(ticketIDs(current), []string{"A"}) {
+				t.Fatalf("by channel: ch1 %v, ch3 %v", ticketIDs(old), ticketIDs(current))
+			}
+			byOld, _ := b.cache.GetFromCacheByOperatorTTId(ctx, "T1")
+			byNew, _ := b.cache.GetFromCacheByOperatorTTId(ctx, "T2")
+			if byOld.TicketID != "" || byNew.TicketID != "A" {
+				t.Fatalf("by operator ticket: T1 %q, T2 %q", byOld.TicketID, byNew.TicketID)
+			}
+		}},
+		{name: "customer lookup", run: func(t *testing.T, b backend) {
+			write(t, b.cache, second, first)
+			records, err := b.cache.GetTicketsByCustomerID(ctx, "c1")
+			if err != nil || !reflect.DeepEqual(ticketIDs(records), []string{"A", "B"}) {
+				t.Fatalf("records = %v, %v", ticketIDs(records), err)
+			}
+			_, err = b.cache.GetFromCacheByCustomerID(ctx, "c1")
+			if !errors.Is(err, ErrAmbiguous) {
+				t.Fatalf("err = %v", err)
+			}
+			err = b.cache.DeleteFromCache(ctx, &CacheRecord{TicketID: "B"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			record, err := b.cache.GetFromCacheByCustomerID(ctx, "c1")
+			if err != nil || record.TicketID != "A" {
+				t.Fatalf("record = %+v, %v", record, err)
+			}
+		}},
+		{name: "delete", run: func(t *testing.T, b backend) {
+			write(t, b.cache, first)
+			err := b.cache.DeleteFromCache(ctx, &CacheRecord{TicketID: "A"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := b.cache.GetFromCacheByTicketID(ctx, "A")
+			byCustomer, _ := b.cache.GetTicketsByCustomerID(ctx, "c1")
+			byOperator, _ := b.cache.GetFromCacheByOperatorTTId(ctx, "T1")
+			if got.TicketID != "" || len(byCustomer) != 0 || byOperator.TicketID != "" {
+				t.Fatalf("deleted record found: %+v, %v, %+v", got, ticketIDs(byCustomer), byOperator)
+			}
+		}},
+		{name: "history", run: func(t *testing.T, b backend) {
+			events := []model.TicketEvent{
+				{TicketID: "A", Direction: model.ToSystem, MessageType: model.Create, RecordedTS: 1},
+				{TicketID: "A", Direction: model.FromSystem, MessageType: model.Create, Status: "working", RecordedTS: 2},
+			}
+			for i := range events {
+				err := b.cache.AppendHistory(ctx, &events[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := b.cache.GetHistory(ctx, "A")
+			if err != nil || !reflect.DeepEqual(got, events) {
+				t.Fatalf("history = %+v, %v", got, err)
+			}
+			got, err = b.cache.GetHistory(ctx, "B")
+			if err != nil || len(got) != 0 {
+				t.Fatalf("history = %+v, %v", got, err)
+			}
+		}},
+		{name: "seen", run: func(t *testing.T, b backend) {
+			seen, err := b.cache.IsSeen(ctx, "m1")
+			if err != nil || seen {
+				t.Fatalf("seen = %v, %v", seen, err)
+			}
+			err = b.cache.MarkSeen(ctx, "m1", 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen, err = b.cache.IsSeen(ctx, "m1")
+			if err != nil || !seen {
+				t.Fatalf("seen = %v, %v", seen, err)
+			}
+		}},
+		{name: "keys", run: func(t *testing.T, b backend) {
+			write(t, b.cache, first, second)
+			err := b.cache.AppendHistory(ctx, &model.TicketEvent{TicketID: "A"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys, err := b.cache.GetAllKeysFromCache(ctx)
+			sort.Strings(keys)
+			if err != nil || !reflect.DeepEqual(keys, []string{ticketKey("A"), ticketKey("B")}) {
+				t.Fatalf("keys = %v, %v", keys, err)
+			}
+		}},
+		{name: "ttl expiry", run: func(t *testing.T, b backend) {
+			write(t, b.cache, first)
+			err := b.cache.AppendHistory(ctx, &model.TicketEvent{TicketID: "A"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = b.cache.MarkSeen(ctx, "m1", 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b.expire()
+			got, _ := b.cache.GetFromCacheByTicketID(ctx, "A")
+			byCustomer, _ := b.cache.GetTicketsByCustomerID(ctx, "c1")
+			byOperator, _ := b.cache.GetFromCacheByOperatorTTId(ctx, "T1")
+			history, _ := b.cache.GetHistory(ctx, "A")
+			keys, _ := b.cache.GetAllKeysFromCache(ctx)
+			seen, _ := b.cache.IsSeen(ctx, "m1")
+			if got.TicketID != "" || len(byCustomer) != 0 || byOperator.TicketID != "" || len(history) != 0 || len(keys) != 0 || seen {
+				t.Fatalf("expired data found: %+v, %v, %+v, %v, %v, %v", got, ticketIDs(byCustomer), byOperator, history, keys, seen)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newBackend(t))
+		})
+	}
+}
@@ -2,9 +2,17 @@ package cache
 
 import (
 	"TController/internal/model"
+	"crypto/rand"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type CacheRecord struct {
+	TicketID                    string         `json:"ticket_id,omitempty"`
 	Source                      string         `json:"source,omitempty"`
 	CustomerInternalID          string         `json:"customer_internal_id,omitempty"`
 	IDChannelOperatorForBilling string         `json:"tt_for_billing,omitempty"`
@@ -19,4 +27,116 @@ type CacheRecord struct {
 	File                        string         `json:"tt_file,omitempty"`
 	Created                     string         `json:"timestamp_start,omitempty"`
 	Modified                    string         `json:"timestamp,omitempty"`
+	Acknowledged                string         `json:"timestamp_ack,omitempty"`
+	FirstResponse               string         `json:"timestamp_first_response,omitempty"`
+	Escalations                 string         `json:"escalations,omitempty"` //сроки SLA, по которым уже отправлена эскалация, через запятую
+	Candidates                  string         `json:"candidates,omitempty"`  //системы для заведения запроса в порядке приоритета, через запятую
+	Declined                    string         `json:"declined,omitempty"`    //системы, отказавшие в заведении или не ответившие, через запятую
+}
+
+// Идентификатор запроса в контроллере (UUID v4), ключ записи в кэше
+func NewTicketID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// Created и Modified хранятся как unix timestamp в секундах, timer сравнивает их с текущим временем
+func Timestamp(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+func ParseTimestamp(timestamp string) (time.Time, error) {
+	i, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(i, 0), nil
+}
+
+func (c *CacheRecord) Escalated(deadline string) bool {
+	return inList(c.Escalations, deadline)
+}
+
+func (c *CacheRecord) AddEscalation(deadline string) {
+	c.Escalations = addToList(c.Escalations, deadline)
+}
+
+func (c *CacheRecord) IsDeclined(system string) bool {
+	return inList(c.Declined, system)
+}
+
+func (c *CacheRecord) AddDeclined(system string) {
+	c.Declined = addToList(c.Declined, system)
+}
+
+// Первая из Candidates система, еще не отказавшая в заведении запроса
+func (c *CacheRecord) NextCandidate() (string, bool) {
+	if c.Candidates == "" {
+		return "", false
+	}
+	for _, system := range strings.Split(c.Candidates, ",") {
+		if !c.IsDeclined(system) {
+			return system, true
+		}
+	}
+	return "", false
+}
+
+func inList(list, item string) bool {
+	for _, e := range strings.Split(list, ",") {
+		if e == item {
+			return true
+		}
+	}
+	return false
+}
+
+func addToList(list, item string) string {
+	if inList(list, item) {
+		return list
+	}
+	if list == "" {
+		return item
+	}
+	return list + "," + item
+}
+
+// Заполненные поля записи в виде пар имя-значение, имена полей совпадают с полями хэша в Redis
+func (c *CacheRecord) setFields() []interface{} {
+	fields := make([]interface{}, 0)
+	value := reflect.ValueOf(c).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		if value.Field(i).IsZero() {
+			continue
+		}
+		fields = append(fields, value.Type().Field(i).Name, value.Field(i).Interface())
+	}
+	return fields
+}
+
+// Перенос в запись только заполненных полей update
+func (c *CacheRecord) merge(update *CacheRecord) {
+	value := reflect.ValueOf(c).Elem()
+	updateValue := reflect.ValueOf(update).Elem()
+	for i := 0; i < updateValue.NumField(); i++ {
+		if updateValue.Field(i).IsZero() {
+			continue
+		}
+		value.Field(i).Set(updateValue.Field(i))
+	}
+}
+
+func sortByCreated(records []*CacheRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Created == records[j].Created {
+			return records[i].TicketID < records[j].TicketID
+		}
+		return records[i].Created < records[j].Created
+	})
 }
@@ -0,0 +1,352 @@
+package cache
+
+import (
+	"TController/internal/model"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Период удаления просроченных записей, журналов и ключей обработанных ответов
+const sweepInterval = time.Minute
+
+type memoryRecord struct {
+	record  CacheRecord
+	expires time.Time
+}
+
+// Кэш в памяти процесса, повторяет поведение apiCache: те же ключи, TTL записи обновляется при каждой записи.
+// Индексы не хранятся, поиск по ним - перебором записей. Просроченные данные удаляются при обращении к ним
+// и при записи, не чаще раза в sweepInterval: к ключам обработанных ответов повторно обычно не обращаются
+type memoryCache struct {
+	mu      sync.Mutex
+	records map[string]memoryRecord
+	history map[string]memoryHistory
+	seen    map[string]time.Time
+	swept   time.Time
+	ttl     int64
+	now     func() time.Time
+	lg      *zap.Logger
+}
+
+type memoryHistory struct {
+	events  []model.TicketEvent
+	expires time.Time
+}
+
+func NewMemoryCache(ttl int64, lg *zap.Logger) Cache {
+	return &memoryCache{
+		records: make(map[string]memoryRecord),
+		history: make(map[string]memoryHistory),
+		seen:    make(map[string]time.Time),
+		ttl:     ttl,
+		now:     time.Now,
+		lg:      lg,
+	}
+}
+
+// Вызывается под m.mu, просроченная запись удаляется при обращении к ней
+func (m *memoryCache) get(key string) (CacheRecord, bool) {
+	stored, ok := m.records[key]
+	if !ok {
+		return CacheRecord{}, false
+	}
+	if !m.now().Before(stored.expires) {
+		delete(m.records, key)
+		return CacheRecord{}, false
+	}
+	return stored.record, true
+}
+
+// Вызывается под m.mu перед записью
+func (m *memoryCache) sweep() {
+	now := m.now()
+	if now.Sub(m.swept) < sweepInterval {
+		return
+	}
+	m.swept = now
+	for key, stored := range m.records {
+		if !now.Before(stored.expires) {
+			delete(m.records, key)
+		}
+	}
+	for ticketID, stored := range m.history {
+		if !now.Before(stored.expires) {
+			delete(m.history, ticketID)
+		}
+	}
+	for key, expires := range m.seen {
+		if !now.Before(expires) {
+			delete(m.seen, key)
+		}
+	}
+}
+
+func (m *memoryCache) put(key string, record CacheRecord) {
+	m.sweep()
+	m.records[key] = memoryRecord{
+		record:  record,
+		expires: m.now().Add(time.Duration(m.ttl) * time.Second),
+	}
+}
+
+// Вызывается под m.mu, записи в порядке создания
+func (m *memoryCache) find(match func(record *CacheRecord) bool) []*CacheRecord {
+	records := make([]*CacheRecord, 0)
+	for key := range m.records {
+		record, ok := m.get(key)
+		if !ok || !match(&record) {
+			continue
+		}
+		records = append(records, &record)
+	}
+	sortByCreated(records)
+	return records
+}
+
+func (m *memoryCache) WriteToCache(ctx context.Context, record *CacheRecord) error {
+	if record.TicketID == "" {
+		return fmt.Errorf("WriteToCache: %w", ErrTicketIDEmpty)
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("WriteToCache: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.put(ticketKey(record.TicketID), *record)
+	return nil
+}
+
+func (m *memoryCache) DeleteFromCache(ctx context.Context, record *CacheRecord) error {
+	if record.TicketID == "" {
+		return fmt.Errorf("DeleteFromCache: %w", ErrTicketIDEmpty)
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("DeleteFromCache: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, ticketKey(record.TicketID))
+	return nil
+}
+
+func (m *memoryCache) UpdateCache(ctx context.Context, record *CacheRecord) error {
+	if record.TicketID == "" {
+		return fmt.Errorf("UpdateCache: %w", ErrTicketIDEmpty)
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("UpdateCache: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := ticketKey(record.TicketID)
+	stored, ok := m.get(key)
+	if !ok {
+		return fmt.Errorf("UpdateCache: %w", ErrNotFound)
+	}
+	if record.Modified == "" {
+		record.Modified = Timestamp(m.now())
+	}
+	stored.merge(record)
+	m.put(key, stored)
+	return nil
+}
+
+func (m *memoryCache) GetFromCacheByKey(ctx context.Context, key string) (*CacheRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return &CacheRecord{}, fmt.Errorf("GetFromCacheByKey: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, _ := m.get(key)
+	return &record, nil
+}
+
+func (m *memoryCache) GetFromCacheByTicketID(ctx context.Context, ticketID string) (*CacheRecord, error) {
+	return m.GetFromCacheByKey(ctx, ticketKey(ticketID))
+}
+
+func (m *memoryCache) GetFromCacheByCustomerID(ctx context.Context, customerInternalID string) (*CacheRecord, error) {
+	records, err := m.GetTicketsByCustomerID(ctx, customerInternalID)
+	if err != nil {
+		return &CacheRecord{}, fmt.Errorf("GetFromCacheByCustomerID: %w", err)
+	}
+	switch len(records) {
+	case 0:
+		return &CacheRecord{}, nil
+	case 1:
+		return records[0], nil
+	}
+	return &CacheRecord{}, fmt.Errorf("GetFromCacheByCustomerID: %w", ErrAmbiguous)
+}
+
+// Как и индекс в Redis, номер указывает на последнюю сохранившую его запись
+func (m *memoryCache) GetFromCacheByOperatorTTId(ctx context.Context, operatorTTId string) (*CacheRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return &CacheRecord{}, fmt.Errorf("GetFromCacheByOperatorTTId: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	records := m.find(func(record *CacheRecord) bool {
+		return record.OperatorTTId == operatorTTId
+	})
+	if len(records) == 0 {
+		return &CacheRecord{}, nil
+	}
+	return records[len(records)-1], nil
+}
+
+func (m *memoryCache) GetTicketsByCustomerID(ctx context.Context, customerInternalID string) ([]*CacheRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("GetTicketsByCustomerID: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.find(func(record *CacheRecord) bool {
+		return record.CustomerInternalID == customerInternalID
+	}), nil
+}
+
+func (m *memoryCache) GetTicketsByIDChannelOperator(ctx context.Context, idChannelOperator string) ([]*CacheRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("GetTicketsByIDChannelOperator: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.find(func(record *CacheRecord) bool {
+		return record.IDChannelOperator == idChannelOperator
+	}), nil
+}
+
+func (m *memoryCache) GetStatusFromCache(ctx context.Context, ticketID string) (string, error) {
+	record, err := m.getExisting(ctx, ticketID)
+	if err != nil {
+		return "", fmt.Errorf("GetStatusFromCache: %w", err)
+	}
+	return string(record.Status), nil
+}
+
+func (m *memoryCache) GetSourceFromCache(ctx context.Context, ticketID string) (string, error) {
+	record, err := m.getExisting(ctx, ticketID)
+	if err != nil {
+		return "", fmt.Errorf("GetSourceFromCache: %w", err)
+	}
+	return record.Source, nil
+}
+
+func (m *memoryCache) GetProcessingSystemFromCache(ctx context.Context, ticketID string) (string, error) {
+	record, err := m.getExisting(ctx, ticketID)
+	if err != nil {
+		return "", fmt.Errorf("GetSourceFromCache: %w", err)
+	}
+	return record.IDChannelOperatorForBilling, nil
+}
+
+func (m *memoryCache) getExisting(ctx context.Context, ticketID string) (CacheRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return CacheRecord{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.get(ticketKey(ticketID))
+	if !ok {
+		return record, ErrNotFound
+	}
+	return record, nil
+}
+
+func (m *memoryCache) GetAllKeysFromCache(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("cache.GetKeysFromCache: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]string, 0, len(m.records))
+	for key := range m.records {
+		if _, ok := m.get(key); ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *memoryCache) AppendHistory(ctx context.Context, event *model.TicketEvent) error {
+	if event.TicketID == "" {
+		return fmt.Errorf("AppendHistory: %w", ErrTicketIDEmpty)
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("AppendHistory: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep()
+	history := m.getHistory(event.TicketID)
+	m.history[event.TicketID] = memoryHistory{
+		events:  append(history, *event),
+		expires: m.now().Add(time.Duration(m.ttl) * time.Second),
+	}
+	return nil
+}
+
+func (m *memoryCache) GetHistory(ctx context.Context, ticketID string) ([]model.TicketEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("GetHistory: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	history := m.getHistory(ticketID)
+	events := make([]model.TicketEvent, len(history))
+	copy(events, history)
+	return events, nil
+}
+
+// Вызывается под m.mu
+func (m *memoryCache) getHistory(ticketID string) []model.TicketEvent {
+	stored, ok := m.history[ticketID]
+	if !ok {
+		return nil
+	}
+	if !m.now().Before(stored.expires) {
+		delete(m.history, ticketID)
+		return nil
+	}
+	return stored.events
+}
+
+func (m *memoryCache) IsSeen(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, fmt.Errorf("IsSeen: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	expires, ok := m.seen[key]
+	if !ok {
+		return false, nil
+	}
+	if !m.now().Before(expires) {
+		delete(m.seen, key)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (m *memoryCache) MarkSeen(ctx context.Context, key string, ttl int64) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("MarkSeen: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep()
+	m.seen[key] = m.now().Add(time.Duration(ttl) * time.Second)
+	return nil
+}
+
+// В памяти записей со старыми ключами не бывает
+func (m *memoryCache) Migrate(ctx context.Context) (int, error) {
+	return 0, nil
+}
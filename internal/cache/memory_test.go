@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"TController/internal/model"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Ключи обработанных ответов и журналы, к которым больше не обращаются, удаляются после истечения TTL
+func TestMemorySweep(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryCache(300, zap.NewNop()).(*memoryCache)
+	now := time.Unix(1700000000, 0)
+	m.now = func() time.Time { return now }
+	const keys = 100
+	for i := 0; i < keys; i++ {
+		err := m.MarkSeen(ctx, fmt.Sprintf("id:%d", i), 30)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.AppendHistory(ctx, &model.TicketEvent{TicketID: fmt.Sprintf("T%d", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.WriteToCache(ctx, &CacheRecord{TicketID: fmt.Sprintf("T%d", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		after   time.Duration
+		seen    int
+		history int
+		records int
+	}{
+		{name: "before sweep interval", after: 45 * time.Second, seen: keys + 1, history: keys + 1, records: keys + 1},
+		{name: "seen keys expired", after: 61 * time.Second, seen: 2, history: keys + 2, records: keys + 2},
+		{name: "history and records expired", after: 5*time.Minute + time.Second, seen: 1, history: 3, records: 3},
+	}
+	for i, tt := range tests {
+		now = time.Unix(1700000000, 0).Add(tt.after)
+		key := fmt.Sprintf("new-%d", i)
+		_ = m.MarkSeen(ctx, key, 30)
+		_ = m.AppendHistory(ctx, &model.TicketEvent{TicketID: key})
+		_ = m.WriteToCache(ctx, &CacheRecord{TicketID: key})
+		if len(m.seen) != tt.seen || len(m.history) != tt.history || len(m.records) != tt.records {
+			t.Fatalf("%s: seen %d, history %d, records %d, want %d, %d, %d",
+				tt.name, len(m.seen), len(m.history), len(m.records), tt.seen, tt.history, tt.records)
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package cache
 
 import (
+	"TController/internal/model"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -13,6 +16,41 @@ const (
 	TIMEOUT = time.Millisecond * 500
 )
 
+// Частичное обновление существующей записи одной операцией: KEYS[1] - ключ, ARGV[1] - TTL, ARGV[2] - TicketID,
+// далее пары поле-значение. Индексы продлеваются вместе с записью, ссылки со старых значений удаляются
+var updateScript = redis.NewScript(1, `
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+local old = redis.call("HMGET", KEYS[1], "CustomerInternalID", "IDChannelOperator", "OperatorTTId")
+redis.call("HSET", KEYS[1], unpack(ARGV, 3))
+redis.call("EXPIRE", KEYS[1], ARGV[1])
+local customer = redis.call("HGET", KEYS[1], "CustomerInternalID")
+if old[1] and old[1] ~= "" and old[1] ~= customer then
+	redis.call("SREM", "Index:CustomerInternalID:" .. old[1], ARGV[2])
+end
+if customer and customer ~= "" then
+	redis.call("SADD", "Index:CustomerInternalID:" .. customer, ARGV[2])
+	redis.call("EXPIRE", "Index:CustomerInternalID:" .. customer, ARGV[1])
+end
+local channel = redis.call("HGET", KEYS[1], "IDChannelOperator")
+if old[2] and old[2] ~= "" and old[2] ~= channel then
+	redis.call("SREM", "Index:IDChannelOperator:" .. old[2], ARGV[2])
+end
+if channel and channel ~= "" then
+	redis.call("SADD", "Index:IDChannelOperator:" .. channel, ARGV[2])
+	redis.call("EXPIRE", "Index:IDChannelOperator:" .. channel, ARGV[1])
+end
+local operator = redis.call("HGET", KEYS[1], "OperatorTTId")
+if old[3] and old[3] ~= "" and old[3] ~= operator and redis.call("GET", "Index:OperatorTTId:" .. old[3]) == ARGV[2] then
+	redis.call("DEL", "Index:OperatorTTId:" .. old[3])
+end
+if operator and operator ~= "" then
+	redis.call("SET", "Index:OperatorTTId:" .. operator, ARGV[2], "EX", ARGV[1])
+end
+return 1
+`)
+
 type apiCache struct {
 	pool *redis.Pool
 	ttl  int64
@@ -33,41 +71,162 @@ func InitCache(url string) *redis.Pool {
 }
 
 func (a *apiCache) WriteToCache(ctx context.Context, record *CacheRecord) error {
+	if record.TicketID == "" {
+		return fmt.Errorf("WriteToCache: %w", ErrTicketIDEmpty)
+	}
 	conn, err := a.pool.GetContext(ctx)
 	if err != nil {
 		return fmt.Errorf("WriteToCache: %w", err)
 	}
 	defer conn.Close()
-	//Ключ для Redis в формате CustomerInternalID:record.CustomerInternalID
-	key := fmt.Sprintf("CustomerInternalID:%s", record.CustomerInternalID)
-	_, err = redis.DoWithTimeout(conn, TIMEOUT, "HSET", redis.Args{}.Add(key).AddFlat(record)...)
+	err = a.write(conn, record)
 	if err != nil {
 		return fmt.Errorf("WriteToCache: %w", err)
 	}
+	return nil
+}
+
+// Запись и индексы пишутся в одной транзакции. Старые значения индексов читаются под WATCH:
+// если запись изменится до EXEC, транзакция отменяется и возвращается ErrConflict.
+// Ключи obsolete удаляются в той же транзакции, их WATCH - на вызывающем
+func (a *apiCache) write(conn redis.Conn, record *CacheRecord, obsolete ...string) error {
+	//Ключ для Redis в формате Ticket:record.TicketID
+	key := ticketKey(record.TicketID)
+	_, err := redis.DoWithTimeout(conn, TIMEOUT, "WATCH", key)
+	if err != nil {
+		return err
+	}
+	old, err := redis.Strings(redis.DoWithTimeout(conn, TIMEOUT, "HMGET", key, "CustomerInternalID", "IDChannelOperator", "OperatorTTId"))
+	if err != nil {
+		return err
+	}
+	var oldOperatorIndex bool
+	if old[2] != "" && old[2] != record.OperatorTTId {
+		oldOperatorIndex, err = ownsOperatorIndex(conn, old[2], record.TicketID)
+		if err != nil {
+			return err
+		}
+	}
+	tx := Multi(conn)
+	tx.Send("HSET", redis.Args{}.Add(key).AddFlat(record)...)
 	//TTL записи в Redis
-	_, err = redis.DoWithTimeout(conn, TIMEOUT, "EXPIRE", key, a.ttl)
+	tx.Send("EXPIRE", key, a.ttl)
+	if old[0] != "" && old[0] != record.CustomerInternalID {
+		tx.Send("SREM", customerIndexKey(old[0]), record.TicketID)
+	}
+	if record.CustomerInternalID != "" {
+		tx.Send("SADD", customerIndexKey(record.CustomerInternalID), record.TicketID)
+		tx.Send("EXPIRE", customerIndexKey(record.CustomerInternalID), a.ttl)
+	}
+	if old[1] != "" && old[1] != record.IDChannelOperator {
+		tx.Send("SREM", channelIndexKey(old[1]), record.TicketID)
+	}
+	if record.IDChannelOperator != "" {
+		tx.Send("SADD", channelIndexKey(record.IDChannelOperator), record.TicketID)
+		tx.Send("EXPIRE", channelIndexKey(record.IDChannelOperator), a.ttl)
+	}
+	if oldOperatorIndex {
+		tx.Send("DEL", operatorTTIdIndexKey(old[2]))
+	}
+	if record.OperatorTTId != "" {
+		tx.Send("SET", operatorTTIdIndexKey(record.OperatorTTId), record.TicketID, "EX", a.ttl)
+	}
+	for _, key := range obsolete {
+		tx.Send("DEL", key)
+	}
+	return tx.Exec()
+}
+
+// Индекс OperatorTTId удаляется, только если указывает на этот запрос: номер мог перейти к новому запросу.
+// Индекс читается под WATCH до EXEC транзакции
+func ownsOperatorIndex(conn redis.Conn, operatorTTId, ticketID string) (bool, error) {
+	_, err := redis.DoWithTimeout(conn, TIMEOUT, "WATCH", operatorTTIdIndexKey(operatorTTId))
 	if err != nil {
-		return fmt.Errorf("WriteToCache: %w", err)
+		return false, err
+	}
+	indexed, err := redis.String(redis.DoWithTimeout(conn, TIMEOUT, "GET", operatorTTIdIndexKey(operatorTTId)))
+	if err != nil && !errors.Is(err, redis.ErrNil) {
+		return false, err
+	}
+	return indexed == ticketID, nil
+}
+
+// Транзакция MULTI/EXEC: первая ошибка отправки сохраняется, остальные команды не отправляются
+type Transaction struct {
+	conn redis.Conn
+	err  error
+}
+
+func Multi(conn redis.Conn) *Transaction {
+	return &Transaction{conn: conn, err: conn.Send("MULTI")}
+}
+
+func (t *Transaction) Send(command string, args ...interface{}) {
+	if t.err == nil {
+		t.err = t.conn.Send(command, args...)
+	}
+}
+
+// Пустой ответ EXEC - транзакция отменена из-за изменения ключей под WATCH,
+// ошибки отдельных команд возвращаются в ответе EXEC
+func (t *Transaction) Exec() error {
+	if t.err != nil {
+		return t.err
+	}
+	replies, err := redis.Values(redis.DoWithTimeout(t.conn, TIMEOUT, "EXEC"))
+	if errors.Is(err, redis.ErrNil) {
+		return ErrConflict
+	}
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if replyErr, ok := reply.(redis.Error); ok {
+			return replyErr
+		}
 	}
 	return nil
 }
 
 func (a *apiCache) DeleteFromCache(ctx context.Context, record *CacheRecord) error {
+	if record.TicketID == "" {
+		return fmt.Errorf("DeleteFromCache: %w", ErrTicketIDEmpty)
+	}
 	conn, err := a.pool.GetContext(ctx)
 	if err != nil {
 		return fmt.Errorf("DeleteFromCache: %w", err)
 	}
 	defer conn.Close()
-	key := fmt.Sprintf("CustomerInternalID:%s", record.CustomerInternalID)
-	_, err = redis.DoWithTimeout(conn, TIMEOUT, "HDEL", key,
-		"status",
-		"source",
-		"id_channel_operator",
-		"id_channel_operator_for_billing",
-		"operator_tt_id",
-		"created",
-		"modified",
-	)
+	//Индексы берем из сохраненной записи, в переданной могут быть заполнены не все поля.
+	//Если запись изменится до EXEC, транзакция отменяется и возвращается ErrConflict
+	_, err = redis.DoWithTimeout(conn, TIMEOUT, "WATCH", ticketKey(record.TicketID))
+	if err != nil {
+		return fmt.Errorf("DeleteFromCache: %w", err)
+	}
+	stored, err := a.get(conn, ticketKey(record.TicketID))
+	if err != nil {
+		return fmt.Errorf("DeleteFromCache: %w", err)
+	}
+	stored.merge(record)
+	var operatorIndex bool
+	if stored.OperatorTTId != "" {
+		operatorIndex, err = ownsOperatorIndex(conn, stored.OperatorTTId, record.TicketID)
+		if err != nil {
+			return fmt.Errorf("DeleteFromCache: %w", err)
+		}
+	}
+	tx := Multi(conn)
+	tx.Send("DEL", ticketKey(record.TicketID))
+	if stored.CustomerInternalID != "" {
+		tx.Send("SREM", customerIndexKey(stored.CustomerInternalID), record.TicketID)
+	}
+	if stored.IDChannelOperator != "" {
+		tx.Send("SREM", channelIndexKey(stored.IDChannelOperator), record.TicketID)
+	}
+	if operatorIndex {
+		tx.Send("DEL", operatorTTIdIndexKey(stored.OperatorTTId))
+	}
+	err = tx.Exec()
 	if err != nil {
 		return fmt.Errorf("DeleteFromCache: %w", err)
 	}
@@ -75,131 +234,372 @@ func (a *apiCache) DeleteFromCache(ctx context.Context, record *CacheRecord) err
 }
 
 func (a *apiCache) UpdateCache(ctx context.Context, record *CacheRecord) error {
-	panic("implement me")
+	if record.TicketID == "" {
+		return fmt.Errorf("UpdateCache: %w", ErrTicketIDEmpty)
+	}
+	conn, err := a.pool.GetContext(ctx)
+	if err != nil {
+		return fmt.Errorf("UpdateCache: %w", err)
+	}
+	defer conn.Close()
+	if record.Modified == "" {
+		record.Modified = Timestamp(time.Now())
+	}
+	ctx, cancel := context.WithTimeout(ctx, TIMEOUT)
+	defer cancel()
+	args := redis.Args{}.Add(ticketKey(record.TicketID), a.ttl, record.TicketID).Add(record.setFields()...)
+	updated, err := redis.Int(updateScript.DoContext(ctx, conn, args...))
+	if err != nil {
+		return fmt.Errorf("UpdateCache: %w", err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("UpdateCache: %w", ErrNotFound)
+	}
+	return nil
+}
+
+func (a *apiCache) GetFromCacheByTicketID(ctx context.Context, ticketID string) (*CacheRecord, error) {
+	return a.GetFromCacheByKey(ctx, ticketKey(ticketID))
 }
 
 func (a *apiCache) GetFromCacheByCustomerID(ctx context.Context, customerInternalID string) (*CacheRecord, error) {
-	var record = CacheRecord{}
+	records, err := a.GetTicketsByCustomerID(ctx, customerInternalID)
+	if err != nil {
+		return &CacheRecord{}, fmt.Errorf("GetFromCacheByCustomerID: %w", err)
+	}
+	switch len(records) {
+	case 0:
+		return &CacheRecord{}, nil
+	case 1:
+		return records[0], nil
+	}
+	return &CacheRecord{}, fmt.Errorf("GetFromCacheByCustomerID: %w", ErrAmbiguous)
+}
+
+func (a *apiCache) GetFromCacheByOperatorTTId(ctx context.Context, operatorTTId string) (*CacheRecord, error) {
 	conn, err := a.pool.GetContext(ctx)
 	if err != nil {
-		return &record, fmt.Errorf("GetFromCacheByKey: %w", err)
+		return &CacheRecord{}, fmt.Errorf("GetFromCacheByOperatorTTId: %w", err)
 	}
 	defer conn.Close()
-	key := fmt.Sprintf("CustomerInternalID:%s", customerInternalID)
-	redisResponce, err := redis.DoWithTimeout(conn, TIMEOUT, "HGETALL", key)
+	index := operatorTTIdIndexKey(operatorTTId)
+	ticketID, err := redis.String(redis.DoWithTimeout(conn, TIMEOUT, "GET", index))
+	if errors.Is(err, redis.ErrNil) {
+		return &CacheRecord{}, nil
+	}
 	if err != nil {
-		return &record, fmt.Errorf("GetFromCacheByKey: %w", err)
+		return &CacheRecord{}, fmt.Errorf("GetFromCacheByOperatorTTId: %w", err)
 	}
-	values, err := redis.Values(redisResponce, nil)
+	record, err := a.get(conn, ticketKey(ticketID))
 	if err != nil {
-		return &record, fmt.Errorf("GetFromCacheByKey: %w", err)
+		return record, fmt.Errorf("GetFromCacheByOperatorTTId: %w", err)
 	}
-	err = redis.ScanStruct(values, &record)
+	//Запись истекла раньше индекса
+	if record.TicketID == "" {
+		_, err = redis.DoWithTimeout(conn, TIMEOUT, "DEL", index)
+		if err != nil {
+			a.lg.Error("cache.GetFromCacheByOperatorTTId", zap.Error(err))
+		}
+	}
+	return record, nil
+}
+
+func (a *apiCache) GetTicketsByCustomerID(ctx context.Context, customerInternalID string) ([]*CacheRecord, error) {
+	records, err := a.getByIndex(ctx, customerIndexKey(customerInternalID))
 	if err != nil {
-		return &record, fmt.Errorf("GetFromCacheByKey: %w", err)
+		return records, fmt.Errorf("GetTicketsByCustomerID: %w", err)
 	}
-	return &record, nil
+	return records, nil
+}
+
+func (a *apiCache) GetTicketsByIDChannelOperator(ctx context.Context, idChannelOperator string) ([]*CacheRecord, error) {
+	records, err := a.getByIndex(ctx, channelIndexKey(idChannelOperator))
+	if err != nil {
+		return records, fmt.Errorf("GetTicketsByIDChannelOperator: %w", err)
+	}
+	return records, nil
+}
+
+// Записи по индексу в порядке создания, ссылки на истекшие записи удаляются из индекса
+func (a *apiCache) getByIndex(ctx context.Context, index string) ([]*CacheRecord, error) {
+	records := make([]*CacheRecord, 0)
+	conn, err := a.pool.GetContext(ctx)
+	if err != nil {
+		return records, err
+	}
+	defer conn.Close()
+	ticketIDs, err := redis.Strings(redis.DoWithTimeout(conn, TIMEOUT, "SMEMBERS", index))
+	if err != nil {
+		return records, err
+	}
+	for _, ticketID := range ticketIDs {
+		record, err := a.get(conn, ticketKey(ticketID))
+		if err != nil {
+			return records, err
+		}
+		if record.TicketID == "" {
+			_, err = redis.DoWithTimeout(conn, TIMEOUT, "SREM", index, ticketID)
+			if err != nil {
+				a.lg.Error("cache.getByIndex", zap.Error(err))
+			}
+			continue
+		}
+		records = append(records, record)
+	}
+	sortByCreated(records)
+	return records, nil
 }
 
 func (a *apiCache) GetFromCacheByKey(ctx context.Context, key string) (*CacheRecord, error) {
-	var record = CacheRecord{}
 	conn, err := a.pool.GetContext(ctx)
 	if err != nil {
-		return &record, fmt.Errorf("GetFromCacheByKey: %w", err)
+		return &CacheRecord{}, fmt.Errorf("GetFromCacheByKey: %w", err)
 	}
 	defer conn.Close()
+	record, err := a.get(conn, key)
+	if err != nil {
+		return record, fmt.Errorf("GetFromCacheByKey: %w", err)
+	}
+	return record, nil
+}
+
+func (a *apiCache) get(conn redis.Conn, key string) (*CacheRecord, error) {
+	var record = CacheRecord{}
 	redisResponce, err := redis.DoWithTimeout(conn, TIMEOUT, "HGETALL", key)
 	if err != nil {
-		return &record, fmt.Errorf("GetFromCacheByKey: %w", err)
+		return &record, err
 	}
 	values, err := redis.Values(redisResponce, nil)
 	if err != nil {
-		return &record, fmt.Errorf("GetFromCacheByKey: %w", err)
+		return &record, err
 	}
 	err = redis.ScanStruct(values, &record)
 	if err != nil {
-		return &record, fmt.Errorf("GetFromCacheByKey: %w", err)
+		return &record, err
 	}
 	return &record, nil
 }
 
-func (a *apiCache) GetStatusFromCache(ctx context.Context, customerInternalID string) (string, error) {
+func (a *apiCache) GetStatusFromCache(ctx context.Context, ticketID string) (string, error) {
 	var status string
 	conn, err := a.pool.GetContext(ctx)
 	if err != nil {
 		return status, fmt.Errorf("GetStatusFromCache: %w", err)
 	}
 	defer conn.Close()
-	key := fmt.Sprintf("CustomerInternalID:%s", customerInternalID)
-	redisResponce, err := redis.DoWithTimeout(conn, TIMEOUT, "HGET", key, "Status")
+	redisResponce, err := redis.DoWithTimeout(conn, TIMEOUT, "HGET", ticketKey(ticketID), "Status")
 	if err != nil {
 		return status, fmt.Errorf("GetStatusFromCache: %w", err)
 	}
 	status, err = redis.String(redisResponce, nil)
+	if errors.Is(err, redis.ErrNil) {
+		return status, fmt.Errorf("GetStatusFromCache: %w", ErrNotFound)
+	}
 	if err != nil {
 		return status, fmt.Errorf("GetStatusFromCache: %w", err)
 	}
 	return status, nil
 }
 
-func (a *apiCache) GetSourceFromCache(ctx context.Context, customerInternalID string) (string, error) {
+func (a *apiCache) GetSourceFromCache(ctx context.Context, ticketID string) (string, error) {
 	var source string
 	conn, err := a.pool.GetContext(ctx)
 	if err != nil {
 		return source, fmt.Errorf("GetSourceFromCache: %w", err)
 	}
 	defer conn.Close()
-	key := fmt.Sprintf("CustomerInternalID:%s", customerInternalID)
-	redisResponce, err := redis.DoWithTimeout(conn, TIMEOUT, "HGET", key, "Source")
+	redisResponce, err := redis.DoWithTimeout(conn, TIMEOUT, "HGET", ticketKey(ticketID), "Source")
 	if err != nil {
 		return source, fmt.Errorf("GetSourceFromCache: %w", err)
 	}
 	source, err = redis.String(redisResponce, nil)
+	if errors.Is(err, redis.ErrNil) {
+		return source, fmt.Errorf("GetSourceFromCache: %w", ErrNotFound)
+	}
 	if err != nil {
 		return source, fmt.Errorf("GetSourceFromCache: %w", err)
 	}
 	return source, nil
 }
 
-func (a *apiCache) GetProcessingSystemFromCache(ctx context.Context, customerInternalID string) (string, error) {
+func (a *apiCache) GetProcessingSystemFromCache(ctx context.Context, ticketID string) (string, error) {
 	var idChannelOperatorForBilling string
 	conn, err := a.pool.GetContext(ctx)
 	if err != nil {
 		return idChannelOperatorForBilling, fmt.Errorf("GetSourceFromCache: %w", err)
 	}
 	defer conn.Close()
-	key := fmt.Sprintf("CustomerInternalID:%s", customerInternalID)
-	redisResponce, err := redis.DoWithTimeout(conn, TIMEOUT, "HGET", key, "IDChannelOperatorForBilling")
+	redisResponce, err := redis.DoWithTimeout(conn, TIMEOUT, "HGET", ticketKey(ticketID), "IDChannelOperatorForBilling")
 	if err != nil {
 		return idChannelOperatorForBilling, fmt.Errorf("GetSourceFromCache: %w", err)
 	}
 	idChannelOperatorForBilling, err = redis.String(redisResponce, nil)
+	if errors.Is(err, redis.ErrNil) {
+		return idChannelOperatorForBilling, fmt.Errorf("GetSourceFromCache: %w", ErrNotFound)
+	}
 	if err != nil {
 		return idChannelOperatorForBilling, fmt.Errorf("GetSourceFromCache: %w", err)
 	}
 	return idChannelOperatorForBilling, nil
 }
 
-func (a *apiCache) GetAllKeysFromCache(ctx context.Context) ([]string, error) {
-	var keys []string
+func (a *apiCache) AppendHistory(ctx context.Context, event *model.TicketEvent) error {
+	if event.TicketID == "" {
+		return fmt.Errorf("AppendHistory: %w", ErrTicketIDEmpty)
+	}
+	value, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("AppendHistory: %w", err)
+	}
 	conn, err := a.pool.GetContext(ctx)
 	if err != nil {
-		return keys, fmt.Errorf("cache.GetKeysFromCache: %w", err)
+		return fmt.Errorf("AppendHistory: %w", err)
 	}
 	defer conn.Close()
-	var cursor = 0
-	var counter = 10000
-	data, err := redis.Values(redis.DoWithTimeout(conn, TIMEOUT, "SCAN", cursor, "COUNT", counter))
+	key := historyKey(event.TicketID)
+	tx := Multi(conn)
+	tx.Send("RPUSH", key, value)
+	tx.Send("EXPIRE", key, a.ttl)
+	err = tx.Exec()
 	if err != nil {
-		return keys, fmt.Errorf("cache.GetKeysFromCache: %w", err)
+		return fmt.Errorf("AppendHistory: %w", err)
+	}
+	return nil
+}
+
+func (a *apiCache) GetHistory(ctx context.Context, ticketID string) ([]model.TicketEvent, error) {
+	events := make([]model.TicketEvent, 0)
+	conn, err := a.pool.GetContext(ctx)
+	if err != nil {
+		return events, fmt.Errorf("GetHistory: %w", err)
+	}
+	defer conn.Close()
+	values, err := redis.ByteSlices(redis.DoWithTimeout(conn, TIMEOUT, "LRANGE", historyKey(ticketID), 0, -1))
+	if err != nil {
+		return events, fmt.Errorf("GetHistory: %w", err)
+	}
+	for _, value := range values {
+		var event model.TicketEvent
+		err = json.Unmarshal(value, &event)
+		if err != nil {
+			return events, fmt.Errorf("GetHistory: %w", err)
+		}
+		events = append(events, event)
 	}
-	cursor, _ = redis.Int(data[0], nil)
-	if cursor != 0 {
-		a.lg.Info("Over 10 000 records in cache")
+	return events, nil
+}
+
+func (a *apiCache) IsSeen(ctx context.Context, key string) (bool, error) {
+	conn, err := a.pool.GetContext(ctx)
+	if err != nil {
+		return false, fmt.Errorf("IsSeen: %w", err)
+	}
+	defer conn.Close()
+	seen, err := redis.Bool(redis.DoWithTimeout(conn, TIMEOUT, "EXISTS", seenKey(key)))
+	if err != nil {
+		return false, fmt.Errorf("IsSeen: %w", err)
 	}
-	keys, err = redis.Strings(data[1], nil)
+	return seen, nil
+}
+
+func (a *apiCache) MarkSeen(ctx context.Context, key string, ttl int64) error {
+	conn, err := a.pool.GetContext(ctx)
+	if err != nil {
+		return fmt.Errorf("MarkSeen: %w", err)
+	}
+	defer conn.Close()
+	_, err = redis.DoWithTimeout(conn, TIMEOUT, "SET", seenKey(key), 1, "EX", ttl)
+	if err != nil {
+		return fmt.Errorf("MarkSeen: %w", err)
+	}
+	return nil
+}
+
+// Ключи записей о запросах, индексы не возвращаются
+func (a *apiCache) GetAllKeysFromCache(ctx context.Context) ([]string, error) {
+	keys, err := a.scan(ctx, ticketKey("*"))
 	if err != nil {
 		return keys, fmt.Errorf("cache.GetKeysFromCache: %w", err)
 	}
 	return keys, nil
 }
+
+func (a *apiCache) scan(ctx context.Context, match string) ([]string, error) {
+	keys := make([]string, 0)
+	conn, err := a.pool.GetContext(ctx)
+	if err != nil {
+		return keys, err
+	}
+	defer conn.Close()
+	var cursor = 0
+	var counter = 10000
+	for {
+		data, err := redis.Values(redis.DoWithTimeout(conn, TIMEOUT, "SCAN", cursor, "MATCH", match, "COUNT", counter))
+		if err != nil {
+			return keys, err
+		}
+		cursor, err = redis.Int(data[0], nil)
+		if err != nil {
+			return keys, err
+		}
+		page, err := redis.Strings(data[1], nil)
+		if err != nil {
+			return keys, err
+		}
+		keys = append(keys, page...)
+		if cursor == 0 {
+			return keys, nil
+		}
+	}
+}
+
+func (a *apiCache) Migrate(ctx context.Context) (int, error) {
+	var migrated int
+	keys, err := a.scan(ctx, "CustomerInternalID:*")
+	if err != nil {
+		return migrated, fmt.Errorf("cache.Migrate: %w", err)
+	}
+	conn, err := a.pool.GetContext(ctx)
+	if err != nil {
+		return migrated, fmt.Errorf("cache.Migrate: %w", err)
+	}
+	defer conn.Close()
+	for _, key := range keys {
+		//Новая запись пишется и старый ключ удаляется одной транзакцией под WATCH старого ключа:
+		//после сбоя запись не дублируется, ключ, перенесенный другим экземпляром, пропускается
+		_, err = redis.DoWithTimeout(conn, TIMEOUT, "WATCH", key)
+		if err != nil {
+			return migrated, fmt.Errorf("cache.Migrate: %w", err)
+		}
+		keyType, err := redis.String(redis.DoWithTimeout(conn, TIMEOUT, "TYPE", key))
+		if err != nil {
+			return migrated, fmt.Errorf("cache.Migrate: %w", err)
+		}
+		record := &CacheRecord{}
+		if keyType == "hash" {
+			record, err = a.get(conn, key)
+			if err != nil {
+				return migrated, fmt.Errorf("cache.Migrate: %w", err)
+			}
+		}
+		if record.CustomerInternalID == "" {
+			_, err = redis.DoWithTimeout(conn, TIMEOUT, "UNWATCH")
+			if err != nil {
+				return migrated, fmt.Errorf("cache.Migrate: %w", err)
+			}
+			continue
+		}
+		record.TicketID = NewTicketID()
+		err = a.write(conn, record, key)
+		if errors.Is(err, ErrConflict) {
+			a.lg.Info("cache record changed during migration, skipped", zap.String("key", key))
+			continue
+		}
+		if err != nil {
+			return migrated, fmt.Errorf("cache.Migrate: %w", err)
+		}
+		a.lg.Info("cache record migrated", zap.String("key", key), zap.String("ticket_id", record.TicketID))
+		migrated++
+	}
+	return migrated, nil
+}
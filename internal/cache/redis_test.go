@@ -0,0 +1,250 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"go.uber.org/zap"
+)
+
+// Соединение, которое записывает отправленные команды и отвечает из replies по имени команды
+type fakeConn struct {
+	replies map[string]interface{}
+	sendErr error
+	sent    []string
+}
+
+func (c *fakeConn) Close() error { return nil }
+func (c *fakeConn) Err() error   { return nil }
+func (c *fakeConn) Flush() error { return nil }
+
+func (c *fakeConn) Do(command string, args ...interface{}) (interface{}, error) {
+	c.sent = append(c.sent, format(command, args))
+	reply := c.replies[command]
+	if err, ok := reply.(error); ok {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (c *fakeConn) DoWithTimeout(_ time.Duration, command string, args ...interface{}) (interface{}, error) {
+	return c.Do(command, args...)
+}
+
+func (c *fakeConn) Send(command string, args ...interface{}) error {
+	if c.sendErr != nil {
+		return c.sendErr
+	}
+	c.sent = append(c.sent, format(command, args))
+	return nil
+}
+
+func (c *fakeConn) Receive() (interface{}, error) { return nil, nil }
+
+func (c *fakeConn) ReceiveWithTimeout(time.Duration) (interface{}, error) { return nil, nil }
+
+func format(command string, args []interface{}) string {
+	parts := []string{command}
+	for _, arg := range args {
+		parts = append(parts, fmt.Sprint(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestWriteIndexes(t *testing.T) {
+	tests := []struct {
+		name    string
+		old     []interface{}
+		indexed interface{}
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "new record",
+			old:  []interface{}{nil, nil, nil},
+			want: []string{"SET Index:OperatorTTId:TT-2 T1 EX 60"},
+		},
+		{
+			name:    "operator id changed",
+			old:     []interface{}{[]byte("C1"), []byte("CH1"), []byte("TT-1")},
+			indexed: []byte("T1"),
+			want:    []string{"WATCH Index:OperatorTTId:TT-1", "DEL Index:OperatorTTId:TT-1", "SET Index:OperatorTTId:TT-2 T1 EX 60"},
+		},
+		{
+			name:    "old operator index points to other ticket",
+			old:     []interface{}{[]byte("C1"), []byte("CH1"), []byte("TT-1")},
+			indexed: []byte("T2"),
+			notWant: []string{"DEL Index:OperatorTTId:TT-1"},
+		},
+		{
+			name: "customer and channel changed",
+			old:  []interface{}{[]byte("C0"), []byte("CH0"), []byte("TT-2")},
+			want: []string{"SREM Index:CustomerInternalID:C0 T1", "SREM Index:IDChannelOperator:CH0 T1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &fakeConn{replies: map[string]interface{}{
+				"HMGET": tt.old,
+				"GET":   tt.indexed,
+				"EXEC":  []interface{}{},
+			}}
+			a := &apiCache{ttl: 60, lg: zap.NewNop()}
+			err := a.write(conn, &CacheRecord{TicketID: "T1", CustomerInternalID: "C1", IDChannelOperator: "CH1", OperatorTTId: "TT-2"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			multi := indexOf(conn.sent, "MULTI")
+			exec := indexOf(conn.sent, "EXEC")
+			if multi < 0 || exec < multi {
+				t.Fatalf("no transaction: %v", conn.sent)
+			}
+			for _, command := range tt.want {
+				i := indexOf(conn.sent, command)
+				if i < 0 {
+					t.Fatalf("%q not sent: %v", command, conn.sent)
+				}
+				//Изменения индексов внутри транзакции, WATCH - до нее
+				inside := i > multi && i < exec
+				if inside == strings.HasPrefix(command, "WATCH") {
+					t.Fatalf("%q at wrong position: %v", command, conn.sent)
+				}
+			}
+			for _, command := range tt.notWant {
+				if indexOf(conn.sent, command) >= 0 {
+					t.Fatalf("%q sent: %v", command, conn.sent)
+				}
+			}
+		})
+	}
+}
+
+func TestTransactionExec(t *testing.T) {
+	sendErr := errors.New("broken pipe")
+	tests := []struct {
+		name    string
+		exec    interface{}
+		sendErr error
+		err     error
+	}{
+		{name: "ok", exec: []interface{}{int64(1), "OK"}},
+		{name: "aborted by watch", exec: nil, err: ErrConflict},
+		{name: "command error", exec: []interface{}{int64(1), redis.Error("WRONGTYPE")}, err: redis.Error("WRONGTYPE")},
+		{name: "send error", sendErr: sendErr, err: sendErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &fakeConn{replies: map[string]interface{}{"EXEC": tt.exec}, sendErr: tt.sendErr}
+			tx := Multi(conn)
+			tx.Send("SET", "a", 1)
+			tx.Send("EXPIRE", "a", 60)
+			err := tx.Exec()
+			if !errors.Is(err, tt.err) && !reflect.DeepEqual(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if tt.sendErr != nil && indexOf(conn.sent, "EXEC") >= 0 {
+				t.Fatal("EXEC sent after send error")
+			}
+		})
+	}
+}
+
+func newFakeCache(conn *fakeConn) *apiCache {
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { return conn, nil }}
+	return &apiCache{pool: pool, ttl: 60, lg: zap.NewNop()}
+}
+
+// Индекс OperatorTTId удаляется вместе с запросом, только если указывает на этот запрос
+func TestDeleteOperatorIndex(t *testing.T) {
+	tests := []struct {
+		name    string
+		indexed interface{}
+		deleted bool
+	}{
+		{name: "own index", indexed: []byte("T1"), deleted: true},
+		{name: "index points to other ticket", indexed: []byte("T2")},
+		{name: "no index", indexed: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &fakeConn{replies: map[string]interface{}{
+				"HGETALL": []interface{}{[]byte("TicketID"), []byte("T1"), []byte("OperatorTTId"), []byte("TT-1")},
+				"GET":     tt.indexed,
+				"EXEC":    []interface{}{},
+			}}
+			err := newFakeCache(conn).DeleteFromCache(context.Background(), &CacheRecord{TicketID: "T1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if indexOf(conn.sent, "WATCH Ticket:T1") < 0 || indexOf(conn.sent, "WATCH Index:OperatorTTId:TT-1") < 0 {
+				t.Fatalf("record and index not watched: %v", conn.sent)
+			}
+			del := indexOf(conn.sent, "DEL Index:OperatorTTId:TT-1")
+			if (del > indexOf(conn.sent, "MULTI")) != tt.deleted {
+				t.Fatalf("index deleted: %v, want %v: %v", del >= 0, tt.deleted, conn.sent)
+			}
+		})
+	}
+}
+
+// Новая запись и удаление старого ключа - одна транзакция под WATCH старого ключа.
+// Ключ, измененный или перенесенный другим экземпляром, пропускается
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name     string
+		keyType  string
+		exec     interface{}
+		migrated int
+	}{
+		{name: "migrated", keyType: "hash", exec: []interface{}{}, migrated: 1},
+		{name: "changed by other instance", keyType: "hash", exec: nil},
+		{name: "already migrated", keyType: "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &fakeConn{replies: map[string]interface{}{
+				"SCAN":    []interface{}{[]byte("0"), []interface{}{[]byte("CustomerInternalID:C1")}},
+				"TYPE":    tt.keyType,
+				"HGETALL": []interface{}{[]byte("CustomerInternalID"), []byte("C1")},
+				"HMGET":   []interface{}{nil, nil, nil},
+				"EXEC":    tt.exec,
+			}}
+			migrated, err := newFakeCache(conn).Migrate(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if migrated != tt.migrated {
+				t.Fatalf("migrated %d, want %d", migrated, tt.migrated)
+			}
+			watch := indexOf(conn.sent, "WATCH CustomerInternalID:C1")
+			del := indexOf(conn.sent, "DEL CustomerInternalID:C1")
+			if watch < 0 || watch > indexOf(conn.sent, "TYPE CustomerInternalID:C1") {
+				t.Fatalf("old key not watched: %v", conn.sent)
+			}
+			if tt.keyType != "hash" {
+				if del >= 0 || indexOf(conn.sent, "MULTI") >= 0 {
+					t.Fatalf("missing key migrated: %v", conn.sent)
+				}
+				return
+			}
+			if del < indexOf(conn.sent, "MULTI") || del > indexOf(conn.sent, "EXEC") {
+				t.Fatalf("old key not deleted in transaction: %v", conn.sent)
+			}
+		})
+	}
+}
+
+func indexOf(sent []string, command string) int {
+	for i := range sent {
+		if sent[i] == command {
+			return i
+		}
+	}
+	return -1
+}
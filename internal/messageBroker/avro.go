@@ -0,0 +1,271 @@
+package messageBroker
+
+import (
+	"TController/internal/model"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var ErrAvroMapping = errors.New("avro record does not match ticket")
+var ErrFieldType = errors.New("unexpected field type")
+
+// Поле записи, которое не удалось преобразовать
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("field %s: %s", e.Field, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// Все ошибки преобразования записи, errors.As(err, &mappingError) дает список полей
+type MappingError struct {
+	Fields []*FieldError
+}
+
+func (e *MappingError) Error() string {
+	fields := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		fields[i] = field.Error()
+	}
+	return fmt.Sprintf("%s: %s", ErrAvroMapping, strings.Join(fields, "; "))
+}
+
+func (e *MappingError) Unwrap() error {
+	return ErrAvroMapping
+}
+
+// Соответствие полей avro записи и model.Ticket, типы avro определяются схемой
+type ticketField struct {
+	name string
+	str  func(ticket *model.Ticket) *string
+	long func(ticket *model.Ticket) *int64
+}
+
+var ticketFields = []ticketField{
+	{name: "tt_request", str: func(t *model.Ticket) *string { return (*string)(&t.MessageType) }},
+	{name: "tt_for_billing", str: func(t *model.Ticket) *string { return &t.IDChannelOperatorForBilling }},
+	{name: "tt_client", str: func(t *model.Ticket) *string { return &t.CustomerInternalId }},
+	{name: "tt_id_channel_operator", str: func(t *model.Ticket) *string { return &t.IDChannelOperator }},
+	{name: "tt_description", str: func(t *model.Ticket) *string { return &t.Description }},
+	{name: "tt_ts_start", long: func(t *model.Ticket) *int64 { return &t.TTStartTimeTS }},
+	{name: "date_in_string", str: func(t *model.Ticket) *string { return &t.TTStartTime }},
+	{name: "tt_problem_type", str: func(t *model.Ticket) *string { return &t.TTClassification }},
+	{name: "tt_file_name", str: func(t *model.Ticket) *string { return &t.FileName }},
+	{name: "tt_file", str: func(t *model.Ticket) *string { return &t.File }},
+	{name: "tt_erth", str: func(t *model.Ticket) *string { return &t.OperatorTTId }},
+	{name: "tt_ts", long: func(t *model.Ticket) *int64 { return &t.EventTimestamp }},
+	{name: "tt_ts_string", str: func(t *model.Ticket) *string { return &t.TimeStampString }},
+	{name: "tt_status", str: func(t *model.Ticket) *string { return &t.TTStatus }},
+	{name: "tt_comment", str: func(t *model.Ticket) *string { return &t.Comment }},
+	{name: "tt_user", str: func(t *model.Ticket) *string { return &t.User }},
+}
+
+// Avro в формате Confluent: байт 0, ID схемы, данные. Запросы кодируются схемой writer,
+// ответы читаются схемой reader независимо от версии схемы, которой они записаны
+type avroCodec struct {
+	registry SchemaRegistry
+	writer   *Schema
+	reader   *Schema
+}
+
+func NewAvroCodec(registry SchemaRegistry, writer *Schema, reader *Schema) Codec {
+	return &avroCodec{registry: registry, writer: writer, reader: reader}
+}
+
+// Сообщение декодируется схемой, которой оно записано (ID в байтах 1-4), и приводится к схеме чтения
+func (c *avroCodec) decode(ctx context.Context, bytes []byte) (interface{}, error) {
+	if len(bytes) < 5 || bytes[0] != 0 {
+		return nil, fmt.Errorf("messageBroker.Decode: %w", ErrWireFormat)
+	}
+	writer, err := c.registry.GetByID(ctx, binary.BigEndian.Uint32(bytes[1:5]))
+	if err != nil {
+		return nil, fmt.Errorf("messageBroker.Decode: %w", err)
+	}
+	data, _, err := writer.Codec.NativeFromBinary(bytes[5:])
+	if err != nil {
+		return nil, fmt.Errorf("messageBroker.Decode: %w", err)
+	}
+	data, err = c.reader.Resolve(writer, data)
+	if err != nil {
+		return nil, fmt.Errorf("messageBroker.Decode: %w", err)
+	}
+	return data, nil
+}
+
+func (c *avroCodec) encode(data map[string]interface{}) ([]byte, error) {
+	bytes := make([]byte, 5)
+	bytes[0] = 0
+	binary.BigEndian.PutUint32(bytes[1:5], c.writer.ID)
+	message, err := c.writer.Codec.BinaryFromNative(bytes, data)
+	if err != nil {
+		return bytes, fmt.Errorf("messageBroker.PushMessage: %w", err)
+	}
+	return message, nil
+}
+
+// Поля, которых нет в записи или со значением null, остаются пустыми, неизвестные поля пропускаются.
+// Ошибки всех полей собираются в *MappingError
+func (c *avroCodec) Decode(ctx context.Context, bytes []byte) (*model.Ticket, error) {
+	decodedMessage, err := c.decode(ctx, bytes)
+	if err != nil {
+		return nil, fmt.Errorf("avroCodec.Decode: %w", err)
+	}
+	record, ok := decodedMessage.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("avroCodec.Decode: %w: record expected, got %T", ErrAvroMapping, decodedMessage)
+	}
+	ticket := &model.Ticket{}
+	mappingError := &MappingError{}
+	for _, field := range ticketFields {
+		value, ok := record[field.name]
+		if !ok {
+			continue
+		}
+		value = unwrapUnion(value)
+		if value == nil {
+			continue
+		}
+		if field.str != nil {
+			err = fromAvroString(value, field.str(ticket))
+		} else {
+			err = fromAvroLong(value, field.long(ticket))
+		}
+		if err != nil {
+			mappingError.Fields = append(mappingError.Fields, &FieldError{Field: field.name, Err: err})
+		}
+	}
+	if len(mappingError.Fields) > 0 {
+		return nil, fmt.Errorf("avroCodec.Decode: %w", mappingError)
+	}
+	ticket.MessageType = parseRequestType(string(ticket.MessageType))
+	return ticket, nil
+}
+
+// В записи передаются только поля, которые есть в схеме отправки, значение приводится к типу поля
+func (c *avroCodec) Encode(ctx context.Context, ticket *model.Ticket) ([]byte, error) {
+	m := make(map[string]interface{}, len(ticketFields))
+	mappingError := &MappingError{}
+	for _, field := range ticketFields {
+		types, ok := c.writer.fields[field.name]
+		if !ok {
+			continue
+		}
+		var value interface{}
+		if field.str != nil {
+			value = *field.str(ticket)
+		} else {
+			value = *field.long(ticket)
+		}
+		native, err := toAvro(types, value)
+		if err != nil {
+			mappingError.Fields = append(mappingError.Fields, &FieldError{Field: field.name, Err: err})
+			continue
+		}
+		m[field.name] = native
+	}
+	if len(mappingError.Fields) > 0 {
+		return nil, fmt.Errorf("avroCodec.Encode: %w", mappingError)
+	}
+	message, err := c.encode(m)
+	if err != nil {
+		return nil, fmt.Errorf("avroCodec.Encode: %w", err)
+	}
+	return message, nil
+}
+
+// goavro передает значение union как {"тип": значение}
+func unwrapUnion(value interface{}) interface{} {
+	union, ok := value.(map[string]interface{})
+	if !ok || len(union) != 1 {
+		return value
+	}
+	for _, branch := range union {
+		return branch
+	}
+	return nil
+}
+
+func fromAvroString(value interface{}, target *string) error {
+	switch v := value.(type) {
+	case string:
+		*target = v
+	case int64:
+		*target = strconv.FormatInt(v, 10)
+	case int32:
+		*target = strconv.FormatInt(int64(v), 10)
+	default:
+		return fmt.Errorf("%w: string expected, got %T", ErrFieldType, value)
+	}
+	return nil
+}
+
+// Системы передают время и строкой, и числом; пустая строка - значение не задано
+func fromAvroLong(value interface{}, target *int64) error {
+	switch v := value.(type) {
+	case int64:
+		*target = v
+	case int32:
+		*target = int64(v)
+	case string:
+		if v == "" {
+			return nil
+		}
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		*target = parsed
+	default:
+		return fmt.Errorf("%w: long expected, got %T", ErrFieldType, value)
+	}
+	return nil
+}
+
+// Значение в native-форме goavro: для union - первая ветка, к типу которой приводится значение
+func toAvro(types []string, value interface{}) (interface{}, error) {
+	for _, avroType := range types {
+		native, ok := convertTo(avroType, value)
+		if !ok {
+			continue
+		}
+		if len(types) == 1 {
+			return native, nil
+		}
+		return map[string]interface{}{avroType: native}, nil
+	}
+	return nil, fmt.Errorf("%w: %T does not fit %v", ErrFieldType, value, types)
+}
+
+func convertTo(avroType string, value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case string:
+		switch avroType {
+		case "string":
+			return v, true
+		case "long":
+			parsed, err := strconv.ParseInt(v, 10, 64)
+			return parsed, err == nil
+		}
+	case int64:
+		switch avroType {
+		case "long":
+			return v, true
+		case "int":
+			return int32(v), v >= math.MinInt32 && v <= math.MaxInt32
+		case "string":
+			return strconv.FormatInt(v, 10), true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,116 @@
+package messageBroker
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// Произвольные байты и искажения корректного сообщения не должны приводить к панике в декодерах
+func TestDecodeArbitraryInput(t *testing.T) {
+	schema := ticketSchema(t, 1)
+	codecs := map[string]Codec{
+		FormatAvro:     NewAvroCodec(newTestRegistry(t, http.StatusOK, map[uint32]*Schema{1: schema}), schema, ticketSchema(t, 2)),
+		FormatJSON:     NewJSONCodec(),
+		FormatProtobuf: NewProtobufCodec(),
+	}
+	valid := map[string][]byte{}
+	for format, codec := range codecs {
+		data, err := codec.Encode(context.Background(), testTicket())
+		if err != nil {
+			t.Fatal(err)
+		}
+		valid[format] = data
+	}
+	corpus := [][]byte{
+		nil,
+		{},
+		{0},
+		{0, 0, 0, 0, 1},
+		{0, 0, 0, 0, 1, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01},
+		{0, 0, 0, 0, 1, 0x80},
+		{0x0a, 0xff, 0xff, 0xff, 0xff, 0x0f},
+		[]byte(`{"tt_request":`),
+		[]byte(`{"tt_ts":"x"}`),
+	}
+	random := rand.New(rand.NewSource(1))
+	for format, codec := range codecs {
+		inputs := append([][]byte{}, corpus...)
+		for i := 0; i < 2000; i++ {
+			//Случайные байты: половина с заголовком avro, чтобы дойти до разбора записи
+			data := make([]byte, random.Intn(64))
+			random.Read(data)
+			if i%2 == 0 {
+				data = append([]byte{0, 0, 0, 0, 1}, data...)
+			}
+			inputs = append(inputs, data)
+			//Корректное сообщение с измененными байтами и обрезанное
+			mutated := append([]byte{}, valid[format]...)
+			for j := random.Intn(4); j >= 0; j-- {
+				mutated[random.Intn(len(mutated))] = byte(random.Intn(256))
+			}
+			inputs = append(inputs, mutated[:random.Intn(len(mutated)+1)])
+		}
+		for _, data := range inputs {
+			decode(t, format, codec, data)
+		}
+	}
+}
+
+func decode(t *testing.T, format string, codec Codec, data []byte) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("%s: panic on input %x: %v", format, data, r)
+		}
+	}()
+	ticket, err := codec.Decode(context.Background(), data)
+	if err == nil && ticket == nil {
+		t.Fatalf("%s: nil ticket without error on input %x", format, data)
+	}
+}
+
+func TestAvroMappingError(t *testing.T) {
+	//Схема записи с типами, которые не приводятся к полям запроса
+	schema := recordSchema(t, 1, `tt_client:"boolean"`, `tt_ts:"string"`, `tt_erth:"string"`, `tt_ts_start:"string"`)
+	codec := NewAvroCodec(newTestRegistry(t, http.StatusOK, map[uint32]*Schema{1: schema}), schema, schema)
+	tests := []struct {
+		name   string
+		record map[string]interface{}
+		fields []string
+	}{
+		{
+			name:   "wrong types",
+			record: map[string]interface{}{"tt_client": true, "tt_ts": "yesterday", "tt_erth": "TT-1", "tt_ts_start": ""},
+			fields: []string{"tt_client", "tt_ts"},
+		},
+		{
+			name:   "one field",
+			record: map[string]interface{}{"tt_client": false, "tt_ts": "1700000000", "tt_erth": "TT-1", "tt_ts_start": "1700000000"},
+			fields: []string{"tt_client"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := schema.Codec.BinaryFromNative([]byte{0, 0, 0, 0, 1}, tt.record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = codec.Decode(context.Background(), data)
+			var mappingError *MappingError
+			if !errors.As(err, &mappingError) || !errors.Is(err, ErrAvroMapping) {
+				t.Fatalf("err = %v, want *MappingError", err)
+			}
+			fields := make([]string, len(mappingError.Fields))
+			for i, field := range mappingError.Fields {
+				fields[i] = field.Field
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Fatalf("fields = %v, want %v: %v", fields, tt.fields, err)
+			}
+		})
+	}
+}
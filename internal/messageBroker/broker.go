@@ -12,23 +12,36 @@ const (
 	//IN_TOPIC  = "b2b-TT_OUT"
 	OUT_TOPIC = "b2b-TT_OUT"
 	Group_ID  = "TicketSystemController"
-	USER      = "service_kafkasmz_uk"
-	PASS      = "nb$ap#K7dx"
-	//USER      = "erkafka"
-	//PASS      = "erkafka"
 	SCHEMA_ID = 92
+
+	HeaderMessageID     = "message_id"
+	HeaderCorrelationID = "correlation_id"
+	HeaderSource        = "source"
+	HeaderMessageType   = "message_type"
+	HeaderVersion       = "controller_version"
 )
 
+// Версия контроллера в заголовке сообщений, задается при сборке:
+// -ldflags "-X TController/internal/messageBroker.Version=..."
+var Version = "dev"
+
 type Broker interface {
-	InitBroker(url string,
-		out chan *model.Ticket,
-		schemaIN uint32,
-		schemaOUT uint32,
+	InitBroker(connection ConnectionConfig,
+		out chan *model.Message,
+		schemas SchemaConfig,
 		groupID string,
-		user string,
-		pass string,
-		registryURL string,
+		dlqTopic string,
+		writerConfig WriterConfig,
 		lg *zap.Logger) error
 	PushMessage(ctx context.Context, topic string, value *model.Ticket) (err error)
+	//key - ключ запроса, к которому относится событие, заголовки - как у запросов
+	PushEvent(ctx context.Context, topic string, key string, value []byte) (err error)
+	//callback вызывается после подтверждения брокером или ошибки отправки
+	PushEventAsync(ctx context.Context, topic string, key string, value []byte, callback func(err error)) error
 	Consumer(ctx context.Context, topic string)
+	//Dead-letter топик: сообщения, которые не удалось разобрать, и их повторная отправка
+	ReadDeadLetters(ctx context.Context, topic string) ([]DeadLetter, error)
+	Replay(ctx context.Context, letter *DeadLetter) error
+	//Отправляет сообщения из очередей и закрывает соединения
+	Close() error
 }
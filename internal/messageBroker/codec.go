@@ -0,0 +1,80 @@
+package messageBroker
+
+import (
+	"TController/internal/model"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrUnknownFormat = errors.New("unknown message format")
+
+// Форматы сообщений топиков
+const (
+	FormatAvro     = "avro"
+	FormatJSON     = "json"
+	FormatProtobuf = "protobuf"
+)
+
+// Преобразование запроса в сообщение топика и обратно
+type Codec interface {
+	Encode(ctx context.Context, ticket *model.Ticket) ([]byte, error)
+	Decode(ctx context.Context, data []byte) (*model.Ticket, error)
+}
+
+// Форматы топиков из списка topic=format, топики без формата - avro
+func (k *kafkaBroker) initCodecs(formats []string) error {
+	k.avro = NewAvroCodec(k.registry, k.schemaIN, k.schemaOUT)
+	k.codecs = make(map[string]Codec)
+	for _, item := range formats {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("topic format %q: expected topic=format", item)
+		}
+		switch parts[1] {
+		case FormatAvro:
+			k.codecs[parts[0]] = k.avro
+		case FormatJSON:
+			k.codecs[parts[0]] = NewJSONCodec()
+		case FormatProtobuf:
+			k.codecs[parts[0]] = NewProtobufCodec()
+		default:
+			return fmt.Errorf("topic %s: %w %q", parts[0], ErrUnknownFormat, parts[1])
+		}
+	}
+	return nil
+}
+
+func (k *kafkaBroker) codec(topic string) Codec {
+	codec, ok := k.codecs[topic]
+	if !ok {
+		return k.avro
+	}
+	return codec
+}
+
+// Системы присылают тип запроса в разном регистре ("Reopen"), неизвестный тип - комментарий.
+// Done - закрытие запроса системой, обрабатывается и передается источникам как Close
+func parseRequestType(requestType string) model.RequestType {
+	switch strings.ToLower(requestType) {
+	case "create":
+		return model.Create
+	case "close", "done":
+		return model.Close
+	case "status":
+		return model.Status
+	case "reopen":
+		return model.Reopen
+	case "wait":
+		return model.Wait
+	case "note":
+		return model.Note
+	default:
+		return model.Note
+	}
+}
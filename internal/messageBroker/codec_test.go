@@ -0,0 +1,93 @@
+package messageBroker
+
+import (
+	"TController/internal/model"
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// Схема со всеми полями ticketFields, номер запроса в системе - union, как в схеме ответов
+func ticketSchema(t testing.TB, id uint32) *Schema {
+	t.Helper()
+	fields := make([]string, 0, len(ticketFields))
+	for _, field := range ticketFields {
+		switch {
+		case field.name == "tt_erth":
+			fields = append(fields, field.name+`:["null","string"],"default":null`)
+		case field.long != nil:
+			fields = append(fields, field.name+`:"long"`)
+		default:
+			fields = append(fields, field.name+`:"string"`)
+		}
+	}
+	return recordSchema(t, id, fields...)
+}
+
+// Запрос со всеми заполненными полями
+func testTicket() *model.Ticket {
+	return &model.Ticket{
+		MessageType:                 model.Status,
+		IDChannelOperatorForBilling: "RIAS_12",
+		CustomerInternalId:          "c1",
+		IDChannelOperator:           "abcd12-x",
+		Description:                 "no link",
+		TTStartTimeTS:               1700000000,
+		TTStartTime:                 "2023-11-14 22:13:20",
+		TTClassification:            "network",
+		FileName:                    "trace.txt",
+		File:                        "dHJhY2U=",
+		OperatorTTId:                "TT-1",
+		EventTimestamp:              1700000100,
+		TimeStampString:             "2023-11-14 22:15:00",
+		TTStatus:                    "in progress",
+		Comment:                     "checking",
+		User:                        "ivanov",
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	schema := ticketSchema(t, 1)
+	codecs := map[string]Codec{
+		FormatAvro:     NewAvroCodec(newTestRegistry(t, http.StatusOK, map[uint32]*Schema{1: schema}), schema, ticketSchema(t, 2)),
+		FormatJSON:     NewJSONCodec(),
+		FormatProtobuf: NewProtobufCodec(),
+	}
+	tests := []struct {
+		requestType model.RequestType
+		want        model.RequestType
+	}{
+		{requestType: model.Create, want: model.Create},
+		{requestType: model.Status, want: model.Status},
+		{requestType: model.Note, want: model.Note},
+		{requestType: model.Wait, want: model.Wait},
+		{requestType: model.Reopen, want: model.Reopen},
+		{requestType: model.Close, want: model.Close},
+		{requestType: model.Done, want: model.Close},
+		{requestType: "Reopen", want: model.Reopen},
+		{requestType: "unknown", want: model.Note},
+		{requestType: "", want: model.Note},
+	}
+	for format, codec := range codecs {
+		for _, tt := range tests {
+			t.Run(format+"/"+string(tt.requestType), func(t *testing.T) {
+				ticket := testTicket()
+				ticket.MessageType = tt.requestType
+				data, err := codec.Encode(context.Background(), ticket)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := codec.Decode(context.Background(), data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := *ticket
+				want.MessageType = tt.want
+				if !reflect.DeepEqual(got, &want) {
+					t.Fatalf("decoded %+v\nwant    %+v", got, &want)
+				}
+			})
+		}
+	}
+}
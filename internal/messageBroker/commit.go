@@ -0,0 +1,49 @@
+package messageBroker
+
+import (
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+)
+
+type inflight struct {
+	message kafka.Message
+	done    bool
+}
+
+// Сообщения обрабатываются параллельно и завершаются не по порядку.
+// Смещение фиксируется только до последнего сообщения партиции, все предыдущие которого обработаны
+type commitQueue struct {
+	mu     sync.Mutex
+	queues map[int][]*inflight
+}
+
+func newCommitQueue() *commitQueue {
+	return &commitQueue{queues: make(map[int][]*inflight)}
+}
+
+// Вызывается в порядке получения сообщений
+func (q *commitQueue) add(message kafka.Message) *inflight {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item := &inflight{message: message}
+	q.queues[message.Partition] = append(q.queues[message.Partition], item)
+	return item
+}
+
+// Сообщение, смещение которого можно зафиксировать после завершения item
+func (q *commitQueue) done(item *inflight) (kafka.Message, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item.done = true
+	queue := q.queues[item.message.Partition]
+	var commit kafka.Message
+	ready := false
+	for len(queue) > 0 && queue[0].done {
+		commit = queue[0].message
+		ready = true
+		queue = queue[1:]
+	}
+	q.queues[item.message.Partition] = queue
+	return commit, ready
+}
@@ -0,0 +1,177 @@
+package messageBroker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+var ErrNoDeadLetterTopic = errors.New("dead-letter topic is not set")
+
+// Заголовки сообщения в dead-letter топике, значение сообщения - исходные байты
+const (
+	HeaderDLQTopic     = "dlq-original-topic"
+	HeaderDLQPartition = "dlq-original-partition"
+	HeaderDLQOffset    = "dlq-original-offset"
+	HeaderDLQError     = "dlq-error"
+	HeaderDLQReplayed  = "dlq-replayed-from"
+)
+
+type DeadLetter struct {
+	Partition         int               `json:"partition"`
+	Offset            int64             `json:"offset"`
+	Time              time.Time         `json:"time"`
+	OriginalTopic     string            `json:"original_topic"`
+	OriginalPartition int               `json:"original_partition"`
+	OriginalOffset    int64             `json:"original_offset"`
+	Error             string            `json:"error"`
+	Key               []byte            `json:"key,omitempty"`
+	Value             []byte            `json:"value"`
+	Headers           map[string]string `json:"headers,omitempty"` //заголовки исходного сообщения
+}
+
+// Сообщение, которое не удалось обработать, сохраняется в dead-letter топик.
+// Смещение такого сообщения можно фиксировать только после успешной записи, поэтому запись
+// повторяется, пока не будет успешной или не завершится ctx: до этого смещения партиции не фиксируются
+func (k *kafkaBroker) deadLetter(ctx context.Context, message kafka.Message, cause error) error {
+	if k.dlqTopic == "" {
+		return ErrNoDeadLetterTopic
+	}
+	headers := append([]kafka.Header{}, message.Headers...)
+	headers = append(headers,
+		kafka.Header{Key: HeaderDLQTopic, Value: []byte(message.Topic)},
+		kafka.Header{Key: HeaderDLQPartition, Value: []byte(strconv.Itoa(message.Partition))},
+		kafka.Header{Key: HeaderDLQOffset, Value: []byte(strconv.FormatInt(message.Offset, 10))},
+		kafka.Header{Key: HeaderDLQError, Value: []byte(cause.Error())},
+	)
+	backoff := retryBackoff
+	for {
+		err := k.write(ctx, k.dlqTopic, kafka.Message{Key: message.Key, Value: message.Value, Headers: headers})
+		if err == nil {
+			break
+		}
+		k.lg.Error("Consumer: dead-letter write retry",
+			zap.String("dlq_topic", k.dlqTopic),
+			zap.Int("partition", message.Partition),
+			zap.Int64("offset", message.Offset),
+			zap.Duration("backoff", backoff),
+			zap.Error(err))
+		backoff, err = wait(ctx, backoff)
+		if err != nil {
+			return fmt.Errorf("messageBroker.deadLetter: %w", err)
+		}
+	}
+	k.lg.Warn("Consumer: message moved to dead-letter topic",
+		zap.String("dlq_topic", k.dlqTopic),
+		zap.Int("partition", message.Partition),
+		zap.Int64("offset", message.Offset),
+		zap.Error(cause))
+	return nil
+}
+
+// Все сообщения dead-letter топика на момент вызова, по всем партициям
+func (k *kafkaBroker) ReadDeadLetters(ctx context.Context, topic string) ([]DeadLetter, error) {
+	letters := make([]DeadLetter, 0)
+	var partitions []kafka.Partition
+	var err error
+	//Метаданные отдает любой доступный брокер
+	for _, broker := range k.brokers {
+		partitions, err = k.conn.LookupPartitions(ctx, "tcp", broker, topic)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return letters, fmt.Errorf("messageBroker.ReadDeadLetters: %w", err)
+	}
+	for _, partition := range partitions {
+		read, err := k.readPartition(ctx, topic, partition.ID)
+		if err != nil {
+			return letters, fmt.Errorf("messageBroker.ReadDeadLetters: %w", err)
+		}
+		letters = append(letters, read...)
+	}
+	return letters, nil
+}
+
+func (k *kafkaBroker) readPartition(ctx context.Context, topic string, partition int) ([]DeadLetter, error) {
+	letters := make([]DeadLetter, 0)
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   k.brokers,
+		Topic:     topic,
+		Partition: partition,
+		MaxBytes:  10e6, // 10MB
+		Dialer:    &k.conn,
+	})
+	defer reader.Close()
+	err := reader.SetOffset(kafka.FirstOffset)
+	if err != nil {
+		return letters, err
+	}
+	lag, err := reader.ReadLag(ctx)
+	if err != nil {
+		return letters, err
+	}
+	for ; lag > 0; lag-- {
+		message, err := reader.FetchMessage(ctx)
+		if err != nil {
+			return letters, err
+		}
+		letters = append(letters, newDeadLetter(message))
+	}
+	return letters, nil
+}
+
+func newDeadLetter(message kafka.Message) DeadLetter {
+	letter := DeadLetter{
+		Partition: message.Partition,
+		Offset:    message.Offset,
+		Time:      message.Time,
+		Key:       message.Key,
+		Value:     message.Value,
+		Headers:   make(map[string]string),
+	}
+	for _, header := range message.Headers {
+		switch header.Key {
+		case HeaderDLQTopic:
+			letter.OriginalTopic = string(header.Value)
+		case HeaderDLQPartition:
+			letter.OriginalPartition, _ = strconv.Atoi(string(header.Value))
+		case HeaderDLQOffset:
+			letter.OriginalOffset, _ = strconv.ParseInt(string(header.Value), 10, 64)
+		case HeaderDLQError:
+			letter.Error = string(header.Value)
+		default:
+			letter.Headers[header.Key] = string(header.Value)
+		}
+	}
+	return letter
+}
+
+// Повторная отправка сообщения в исходный топик с исходными ключом и заголовками
+func (k *kafkaBroker) Replay(ctx context.Context, letter *DeadLetter) error {
+	if letter.OriginalTopic == "" {
+		return fmt.Errorf("messageBroker.Replay: original topic of offset %d is unknown", letter.Offset)
+	}
+	headers := make([]kafka.Header, 0, len(letter.Headers)+1)
+	for key, value := range letter.Headers {
+		if key == HeaderDLQReplayed {
+			continue
+		}
+		headers = append(headers, kafka.Header{Key: key, Value: []byte(value)})
+	}
+	headers = append(headers, kafka.Header{
+		Key:   HeaderDLQReplayed,
+		Value: []byte(fmt.Sprintf("%d/%d", letter.Partition, letter.Offset)),
+	})
+	err := k.write(ctx, letter.OriginalTopic, kafka.Message{Key: letter.Key, Value: letter.Value, Headers: headers})
+	if err != nil {
+		return fmt.Errorf("messageBroker.Replay: %w", err)
+	}
+	return nil
+}
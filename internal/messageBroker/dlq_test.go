@@ -0,0 +1,197 @@
+package messageBroker
+
+import (
+	"TController/internal/model"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+type failingCodec struct {
+	err error
+}
+
+func (c failingCodec) Encode(ctx context.Context, ticket *model.Ticket) ([]byte, error) {
+	return nil, c.err
+}
+
+func (c failingCodec) Decode(ctx context.Context, data []byte) (*model.Ticket, error) {
+	return nil, c.err
+}
+
+func TestReceiveUndecodable(t *testing.T) {
+	retryBackoff, maxRetryBackoff = time.Millisecond, 2*time.Millisecond
+	defer func() { retryBackoff, maxRetryBackoff = time.Second, time.Minute }()
+	message := kafka.Message{
+		Topic:     "replies",
+		Partition: 1,
+		Offset:    42,
+		Key:       []byte("key"),
+		Value:     []byte("garbage"),
+		Headers:   []kafka.Header{{Key: HeaderMessageID, Value: []byte("m1")}},
+	}
+	tests := []struct {
+		name     string
+		dlqTopic string
+		writeErr error
+		failures int
+		done     bool
+	}{
+		{name: "moved to dead-letter topic", dlqTopic: "dlq", done: true},
+		//Writer делает 3 попытки, первая запись не удается целиком
+		{name: "dead-letter write retried", dlqTopic: "dlq", writeErr: errors.New("broker is down"), failures: 3, done: true},
+		{name: "dead-letter write failed until stopped", dlqTopic: "dlq", writeErr: errors.New("broker is down")},
+		{name: "dead-letter topic not set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := &fakeTransport{err: tt.writeErr, failures: tt.failures}
+			k := newTestBroker(transport, WriterConfig{})
+			k.dlqTopic = tt.dlqTopic
+			defer k.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if tt.writeErr != nil && tt.failures == 0 {
+				ctx, cancel = context.WithTimeout(ctx, 200*time.Millisecond)
+				defer cancel()
+			}
+			done := false
+			k.receive(ctx, failingCodec{err: ErrWireFormat}, message, func() { done = true })
+			if done != tt.done {
+				t.Fatalf("done = %v, want %v", done, tt.done)
+			}
+			if !tt.done {
+				return
+			}
+			sent := transport.sent()
+			if len(sent) != 1 {
+				t.Fatalf("dead letters: %d", len(sent))
+			}
+			letter := newDeadLetter(kafka.Message{Key: sent[0].Key, Value: sent[0].Value, Headers: sent[0].Headers})
+			if sent[0].Topic != "dlq" || letter.OriginalTopic != "replies" || letter.OriginalPartition != 1 || letter.OriginalOffset != 42 {
+				t.Fatalf("dead letter %+v", letter)
+			}
+			if string(letter.Value) != "garbage" || letter.Headers[HeaderMessageID] != "m1" || letter.Error == "" {
+				t.Fatalf("dead letter %+v", letter)
+			}
+		})
+	}
+}
+
+// Пока запись в dead-letter топик не удается, смещение партиции не фиксируется дальше сообщения,
+// после успешной записи фиксируются и все следующие обработанные сообщения
+func TestDeadLetterRetryResumesCommits(t *testing.T) {
+	retryBackoff, maxRetryBackoff = time.Millisecond, 2*time.Millisecond
+	defer func() { retryBackoff, maxRetryBackoff = time.Second, time.Minute }()
+	transport := &fakeTransport{err: errors.New("broker is down"), failures: 6}
+	k := newTestBroker(transport, WriterConfig{})
+	k.out = make(chan *model.Message, 1)
+	defer k.Close()
+	commits := newCommitQueue()
+	first := kafka.Message{Topic: "replies", Offset: 1, Value: []byte("reply")}
+	second := kafka.Message{Topic: "replies", Offset: 2, Value: []byte("reply")}
+	firstItem := commits.add(first)
+	secondItem := commits.add(second)
+	_, ok := commits.done(secondItem)
+	if ok {
+		t.Fatal("committed past an unfinished message")
+	}
+	var committed []int64
+	k.receive(context.Background(), failingCodec{}, first, func() {
+		if commit, ok := commits.done(firstItem); ok {
+			committed = append(committed, commit.Offset)
+		}
+	})
+	message := <-k.out
+	err := message.DeadLetter(context.Background(), errors.New("handler failed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	message.Done()
+	if len(committed) != 1 || committed[0] != 2 || len(transport.sent()) != 1 {
+		t.Fatalf("committed %v, dead letters %d", committed, len(transport.sent()))
+	}
+}
+
+// Запись обработчиком прекращается при остановке consumer, сообщение не завершается
+func TestDeadLetterStopsWithConsumer(t *testing.T) {
+	retryBackoff, maxRetryBackoff = time.Millisecond, 2*time.Millisecond
+	defer func() { retryBackoff, maxRetryBackoff = time.Second, time.Minute }()
+	transport := &fakeTransport{err: errors.New("broker is down")}
+	k := newTestBroker(transport, WriterConfig{})
+	k.out = make(chan *model.Message, 1)
+	defer k.Close()
+	consumer, stop := context.WithCancel(context.Background())
+	k.receive(consumer, failingCodec{}, kafka.Message{Topic: "replies", Value: []byte("reply")}, func() {})
+	message := <-k.out
+	time.AfterFunc(50*time.Millisecond, stop)
+	err := message.DeadLetter(context.Background(), errors.New("handler failed"))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want %v", err, context.Canceled)
+	}
+}
+
+// Возвращает ошибку реестра первые failures раз
+type flakyCodec struct {
+	failures int
+	calls    int
+}
+
+func (c *flakyCodec) Encode(ctx context.Context, ticket *model.Ticket) ([]byte, error) {
+	return nil, nil
+}
+
+func (c *flakyCodec) Decode(ctx context.Context, data []byte) (*model.Ticket, error) {
+	c.calls++
+	if c.calls <= c.failures {
+		return nil, &RetryableError{Err: ErrSchemaRegistry}
+	}
+	return &model.Ticket{OperatorTTId: "TT-1"}, nil
+}
+
+func TestReceiveRetriesRegistryErrors(t *testing.T) {
+	retryBackoff, maxRetryBackoff = time.Millisecond, 2*time.Millisecond
+	defer func() { retryBackoff, maxRetryBackoff = time.Second, time.Minute }()
+	message := kafka.Message{Topic: "replies", Offset: 7, Value: []byte("data")}
+
+	t.Run("decoded after retries", func(t *testing.T) {
+		transport := &fakeTransport{}
+		k := newTestBroker(transport, WriterConfig{})
+		k.out = make(chan *model.Message, 1)
+		defer k.Close()
+		codec := &flakyCodec{failures: 3}
+		k.receive(context.Background(), codec, message, func() {})
+		if codec.calls != 4 {
+			t.Fatalf("decode calls = %d", codec.calls)
+		}
+		got := <-k.out
+		if got.Ticket.OperatorTTId != "TT-1" || len(transport.sent()) != 0 {
+			t.Fatalf("ticket %+v, dead letters %d", got.Ticket, len(transport.sent()))
+		}
+	})
+
+	t.Run("stopped while retrying", func(t *testing.T) {
+		transport := &fakeTransport{}
+		k := newTestBroker(transport, WriterConfig{})
+		defer k.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		done := false
+		k.receive(ctx, &flakyCodec{failures: 1 << 30}, message, func() { done = true })
+		if done || len(transport.sent()) != 0 {
+			t.Fatalf("done = %v, dead letters %d", done, len(transport.sent()))
+		}
+	})
+}
+
+func TestInitBrokerRequiresDeadLetterTopic(t *testing.T) {
+	k := NewKafkaBroker()
+	err := k.InitBroker(ConnectionConfig{Brokers: []string{"localhost:9092"}}, nil, SchemaConfig{}, "group", "", WriterConfig{}, zap.NewNop())
+	if !errors.Is(err, ErrNoDeadLetterTopic) {
+		t.Fatalf("err = %v", err)
+	}
+}
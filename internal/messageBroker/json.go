@@ -0,0 +1,33 @@
+package messageBroker
+
+import (
+	"TController/internal/model"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// JSON с полями model.Ticket (tt_request, tt_erth, ...)
+type jsonCodec struct{}
+
+func NewJSONCodec() Codec {
+	return &jsonCodec{}
+}
+
+func (c *jsonCodec) Encode(ctx context.Context, ticket *model.Ticket) ([]byte, error) {
+	data, err := json.Marshal(ticket)
+	if err != nil {
+		return nil, fmt.Errorf("jsonCodec.Encode: %w", err)
+	}
+	return data, nil
+}
+
+func (c *jsonCodec) Decode(ctx context.Context, data []byte) (*model.Ticket, error) {
+	ticket := &model.Ticket{}
+	err := json.Unmarshal(data, ticket)
+	if err != nil {
+		return nil, fmt.Errorf("jsonCodec.Decode: %w", err)
+	}
+	ticket.MessageType = parseRequestType(string(ticket.MessageType))
+	return ticket, nil
+}
@@ -3,118 +3,174 @@ package messageBroker
 import (
 	"TController/internal/model"
 	"context"
-	"encoding/binary"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"net/http"
-	"strconv"
+	"sync"
 	"time"
 
-	"github.com/linkedin/goavro"
 	"github.com/segmentio/kafka-go"
-	"github.com/segmentio/kafka-go/sasl/plain"
 	"go.uber.org/zap"
 )
 
+// Повторный разбор сообщения при недоступном реестре схем и повторная запись в dead-letter топик
+var (
+	retryBackoff    = time.Second
+	maxRetryBackoff = time.Minute
+)
+
 type kafkaBroker struct {
+	mu           sync.Mutex
+	producers    map[string]*producer
+	closed       bool
+	sending      sync.WaitGroup
+	writerConfig WriterConfig
 	conn         kafka.Dialer
-	url          string
+	brokers      []string
+	transport    kafka.RoundTripper
 	reader       kafka.Reader
 	writer       kafka.Writer
-	out          chan *model.Ticket
-	schemaINstr  string
-	schemaIN     uint32
-	schemaOUTstr string
-	schemaOUT    uint32
+	out          chan *model.Message
+	registry     SchemaRegistry
+	schemaIN     *Schema //схема отправляемых запросов
+	schemaOUT    *Schema //схема чтения ответов, в нее приводятся сообщения любой версии
+	avro         Codec
+	codecs       map[string]Codec //форматы топиков, кроме avro
 	groupID      string
-	user         string
-	pass         string
-	registryURL  string
+	dlqTopic     string
 	topicIN      string
 	topicOUT     string
 	lg           *zap.Logger
 }
 
 func NewKafkaBroker() Broker {
-	return &kafkaBroker{}
+	return &kafkaBroker{producers: make(map[string]*producer)}
 }
 
-func (k *kafkaBroker) InitBroker(url string,
-	out chan *model.Ticket,
-	schemaIN uint32,
-	schemaOUT uint32,
+func (k *kafkaBroker) InitBroker(connection ConnectionConfig,
+	out chan *model.Message,
+	schemas SchemaConfig,
 	groupID string,
-	user string,
-	pass string,
-	registryURL string,
+	dlqTopic string,
+	writerConfig WriterConfig,
 	lg *zap.Logger) error {
 
-	k.url = url
+	k.brokers = connection.Brokers
 	k.out = out
 	k.lg = lg
-	k.user = user
-	k.pass = pass
 	k.groupID = groupID
-
-	mechanism := plain.Mechanism{
-		Username: k.user,
-		Password: k.pass,
+	k.dlqTopic = dlqTopic
+	k.writerConfig = writerConfig
+	if k.writerConfig.BatchSize < 1 {
+		k.writerConfig.BatchSize = 1
+	}
+	if k.writerConfig.BatchTimeout <= 0 {
+		k.writerConfig.BatchTimeout = 10 * time.Millisecond
 	}
 
+	if len(k.brokers) == 0 {
+		return fmt.Errorf("MessageBroker.InitBroker: broker list is empty")
+	}
+	//Без dead-letter топика неразобранное сообщение нельзя ни обработать, ни пропустить
+	if k.dlqTopic == "" {
+		return fmt.Errorf("MessageBroker.InitBroker: %w", ErrNoDeadLetterTopic)
+	}
+	tlsConfig, err := connection.tlsConfig()
+	if err != nil {
+		return fmt.Errorf("MessageBroker.InitBroker: %w", err)
+	}
+	mechanism, err := connection.mechanism()
+	if err != nil {
+		return fmt.Errorf("MessageBroker.InitBroker: %w", err)
+	}
 	k.conn = kafka.Dialer{
 		Timeout:       10 * time.Second,
 		DualStack:     true,
 		SASLMechanism: mechanism,
+		TLS:           tlsConfig,
+	}
+	k.transport = &kafka.Transport{
+		SASL: mechanism,
+		TLS:  tlsConfig,
 	}
-	var err error
-	//Импорт из реестра avro схемы для отправки
-	k.schemaINstr, err = k.getScheme(registryURL, schemaIN)
+	k.registry = NewSchemaRegistry(schemas.RegistryURL, lg)
+	err = k.loadSchemas(context.Background(), schemas)
 	if err != nil {
 		return fmt.Errorf("MessageBroker.InitBroker: %w", err)
 	}
-	//Импорт из реестра avro схемы для приема
-	k.schemaOUTstr, err = k.getScheme(registryURL, schemaOUT)
+	err = k.initCodecs(schemas.Formats)
 	if err != nil {
 		return fmt.Errorf("MessageBroker.InitBroker: %w", err)
 	}
 	return nil
 }
 
-func (k *kafkaBroker) PushMessage(ctx context.Context, topic string, ticket *model.Ticket) (err error) {
-	mechanism := plain.Mechanism{
-		Username: k.user,
-		Password: k.pass,
-	}
-
-	writer := kafka.Writer{
-		Addr:         kafka.TCP(k.url),
+func (k *kafkaBroker) newWriter(topic string) *kafka.Writer {
+	return &kafka.Writer{
+		Addr:         kafka.TCP(k.brokers...),
 		Topic:        topic,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		MaxAttempts:  3,
+		BatchSize:    k.writerConfig.BatchSize,
+		BatchTimeout: k.writerConfig.BatchTimeout,
 		RequiredAcks: kafka.RequireAll,
-		Transport: &kafka.Transport{
-			SASL: mechanism,
-		},
+		Balancer:     &kafka.Murmur2Balancer{}, //как у Java клиентов: одинаковый ключ - одна партиция
+		Transport:    k.transport,
 	}
-	defer writer.Close()
-	log.Printf("send message: %v", ticket)
-	message, err := k.ticketToBinaryConverter(ticket)
+}
+
+func (k *kafkaBroker) PushMessage(ctx context.Context, topic string, ticket *model.Ticket) (err error) {
+	k.lg.Info("send message",
+		zap.String("topic", topic),
+		zap.String("message_type", string(ticket.MessageType)),
+		zap.String("tt_client", ticket.CustomerInternalId),
+		zap.String("tt_erth", ticket.OperatorTTId),
+		zap.String("correlation_id", model.CorrelationID(ctx)))
+	message, err := k.codec(topic).Encode(ctx, ticket)
 	if err != nil {
 		return fmt.Errorf("messageBroker.PushMessage: %w", err)
 	}
-	err = writer.WriteMessages(ctx, kafka.Message{Value: message})
+	headers := append([]kafka.Header{{Key: HeaderMessageType, Value: []byte(ticket.MessageType)}}, headers(ctx)...)
+	//Запросы по одному клиенту попадают в одну партицию и обрабатываются системой по порядку
+	err = k.write(ctx, topic, kafka.Message{Key: []byte(ticket.Key()), Value: message, Headers: headers})
 	if err != nil {
 		return fmt.Errorf("messageBroker.PushMessage: %w", err)
 	}
 	return nil
 }
 
+// Отправка служебных событий в JSON без avro схемы. Ключ - ключ запроса, к которому относится событие:
+// события и запросы по одному клиенту попадают в одну партицию
+func (k *kafkaBroker) PushEvent(ctx context.Context, topic string, key string, value []byte) (err error) {
+	err = k.write(ctx, topic, kafka.Message{Key: []byte(key), Value: value, Headers: headers(ctx)})
+	if err != nil {
+		return fmt.Errorf("messageBroker.PushEvent: %w", err)
+	}
+	return nil
+}
+
+func (k *kafkaBroker) PushEventAsync(ctx context.Context, topic string, key string, value []byte, callback func(err error)) error {
+	err := k.writeAsync(ctx, topic, kafka.Message{Key: []byte(key), Value: value, Headers: headers(ctx)}, callback)
+	if err != nil {
+		return fmt.Errorf("messageBroker.PushEventAsync: %w", err)
+	}
+	return nil
+}
+
+// Версия контроллера, сквозной идентификатор и источник из ctx
+func headers(ctx context.Context) []kafka.Header {
+	headers := []kafka.Header{{Key: HeaderVersion, Value: []byte(Version)}}
+	if correlationID := model.CorrelationID(ctx); correlationID != "" {
+		headers = append(headers, kafka.Header{Key: HeaderCorrelationID, Value: []byte(correlationID)})
+	}
+	if source := model.Source(ctx); source != "" {
+		headers = append(headers, kafka.Header{Key: HeaderSource, Value: []byte(source)})
+	}
+	return headers
+}
+
 func (k *kafkaBroker) Consumer(ctx context.Context, topic string) {
 	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:     []string{k.url},
+		Brokers:     k.brokers,
 		Topic:       topic,
 		Partition:   0,
 		GroupID:     k.groupID,
@@ -123,195 +179,117 @@ func (k *kafkaBroker) Consumer(ctx context.Context, topic string) {
 		MaxBytes:    10e6, // 10MB
 		Dialer:      &k.conn,
 	})
+	codec := k.codec(topic)
+	commits := newCommitQueue()
 	go func() {
+		defer reader.Close()
 		for {
-			var ticket *model.Ticket
-			message, err := reader.ReadMessage(ctx)
-			k.lg.Info("got message from kafka")
+			message, err := reader.FetchMessage(ctx)
 			if err != nil {
-				k.lg.Error("Consumer.ReadMessage", zap.Error(err)) //todo сделать канал для приема ошибок из kafka
+				if ctx.Err() != nil {
+					return
+				}
+				k.lg.Error("Consumer.FetchMessage", zap.Error(err)) //todo сделать канал для приема ошибок из kafka
 				continue
 			}
-
-			ticket, err = k.binaryToTicketConverter(message.Value)
-			if err != nil {
-				k.lg.Error("Consumer.ReadMessage", zap.Error(err))
-				continue
-			}
-			k.out <- ticket
+			k.lg.Info("got message from kafka")
+			item := commits.add(message)
+			k.receive(ctx, codec, message, func() {
+				commit, ok := commits.done(item)
+				if !ok {
+					return
+				}
+				err := reader.CommitMessages(ctx, commit)
+				if err != nil {
+					k.lg.Error("Consumer.CommitMessages", zap.Int64("offset", commit.Offset), zap.Error(err))
+				}
+			})
 		}
 	}()
 }
 
-func (k *kafkaBroker) decodeAvro(bytes []byte) (interface{}, error) {
-	avroCodec, err := goavro.NewCodec(k.schemaINstr)
+// Разбирает сообщение и передает его на обработку. Неразобранное сообщение завершается только
+// после записи в dead-letter топик, иначе смещение не фиксируется и сообщение будет прочитано повторно
+func (k *kafkaBroker) receive(ctx context.Context, codec Codec, message kafka.Message, done func()) {
+	ticket, err := k.decode(ctx, codec, message)
 	if err != nil {
-		return bytes, fmt.Errorf("messageBroker.Decode: %w", err)
-	}
-	bytes = bytes[5:]
-	data, _, err := avroCodec.NativeFromBinary(bytes)
-	if err != nil {
-		return bytes, fmt.Errorf("messageBroker.Decode: %w", err)
-	}
-	return data, nil
-}
-
-func (k *kafkaBroker) encodeAvro(data map[string]interface{}) ([]byte, error) {
-	bytes := make([]byte, 5)
-	avroCodec, err := goavro.NewCodec(k.schemaINstr)
-	if err != nil {
-		return bytes, fmt.Errorf("messageBroker.PushMessage: %w", err)
+		//Consumer остановлен во время повторов, сообщение будет прочитано заново
+		if ctx.Err() != nil {
+			return
+		}
+		dlqErr := k.deadLetter(ctx, message, err)
+		if dlqErr != nil {
+			k.lg.Error("Consumer: message is not committed",
+				zap.Int("partition", message.Partition),
+				zap.Int64("offset", message.Offset),
+				zap.NamedError("cause", err),
+				zap.Error(dlqErr))
+			return
+		}
+		done()
+		return
 	}
-	log.Printf("Created schema %s", avroCodec.Schema())
-	bytes[0] = 0
-	binary.BigEndian.PutUint32(bytes[1:5], k.schemaIN)
-	message, err := avroCodec.BinaryFromNative(bytes, data)
-	if err != nil {
-		return bytes, fmt.Errorf("messageBroker.PushMessage: %w", err)
+	k.out <- &model.Message{
+		ID:            header(message, HeaderMessageID),
+		CorrelationID: header(message, HeaderCorrelationID),
+		Ticket:        ticket,
+		Done:          done,
+		DeadLetter: func(handler context.Context, cause error) error {
+			//Повторы записи прекращаются и при остановке consumer
+			stopped, cancel := context.WithCancel(handler)
+			defer cancel()
+			go func() {
+				select {
+				case <-ctx.Done():
+					cancel()
+				case <-stopped.Done():
+				}
+			}()
+			return k.deadLetter(stopped, message, cause)
+		},
 	}
-	return message, nil
 }
 
-func (k *kafkaBroker) binaryToTicketConverter(bytes []byte) (*model.Ticket, error) {
-	var ticket *model.Ticket
-	decodedMessage, err := k.decodeAvro(bytes)
-	if err != nil {
-		return ticket, fmt.Errorf("binaryToTicketConverter: %w", err)
-	}
-	unwrapMessageStage1 := decodedMessage.(map[string]interface{})
-	m := make(map[string]string)
-	for key, value := range unwrapMessageStage1 {
-		if value == nil {
-			continue
+// Временные ошибки реестра схем не отправляют сообщение в dead-letter топик:
+// разбор повторяется, пока не будет успешным или не завершится ctx
+func (k *kafkaBroker) decode(ctx context.Context, codec Codec, message kafka.Message) (*model.Ticket, error) {
+	backoff := retryBackoff
+	for {
+		ticket, err := codec.Decode(ctx, message.Value)
+		if err == nil || !retryable(err) {
+			return ticket, err
 		}
-		unwrapMessageStage2 := value.(map[string]interface{})
-		if unwrapMessageStage2["string"] == nil {
-			continue
+		k.lg.Warn("Consumer: decode retry",
+			zap.Int("partition", message.Partition),
+			zap.Int64("offset", message.Offset),
+			zap.Duration("backoff", backoff),
+			zap.Error(err))
+		backoff, err = wait(ctx, backoff)
+		if err != nil {
+			return nil, err
 		}
-		str := unwrapMessageStage2["string"].(string)
-		m[key] = str
-	}
-
-	ttStartTimeTS64, err := strconv.ParseInt(m["tt_ts_start"], 10, 64)
-	eventTimestamp64, err := strconv.ParseInt(m["tt_ts"], 10, 64)
-
-	ticket = &model.Ticket{
-		IDChannelOperatorForBilling: m["tt_for_billing"],
-		CustomerInternalId:          m["tt_client"],
-		IDChannelOperator:           m["tt_id_channel_operator"],
-		Description:                 m["tt_description"],
-		TTStartTimeTS:               ttStartTimeTS64,
-		TTClassification:            m["tt_problem_type"],
-		FileName:                    m["tt_file_name"],
-		File:                        m["tt_file"],
-		OperatorTTId:                m["tt_erth"],
-		EventTimestamp:              eventTimestamp64,
-		TTStatus:                    m["tt_status"],
-		Comment:                     m["tt_comment"],
-		User:                        m["tt_user"],
 	}
-	switch m["tt_request"] {
-	case "create":
-		ticket.MessageType = model.Create
-	case "close":
-		ticket.MessageType = model.Close
-	case "status":
-		ticket.MessageType = model.Status
-	case "Reopen":
-		ticket.MessageType = model.Reopen
-	case "wait":
-		ticket.MessageType = model.Wait
-	case "note":
-		ticket.MessageType = model.Note
-	default:
-		ticket.MessageType = model.Note
-	}
-	return ticket, nil
 }
 
-func (k *kafkaBroker) ticketToBinaryConverter(ticket *model.Ticket) ([]byte, error) {
-	var requestType string
-	requestType = string(ticket.MessageType)
-	m := map[string]interface{}{
-		"tt_request": map[string]interface{}{
-			"string": requestType,
-		},
-		"tt_for_billing": map[string]interface{}{
-			"string": ticket.IDChannelOperatorForBilling,
-		},
-		"tt_client": map[string]interface{}{
-			"string": ticket.CustomerInternalId,
-		},
-		"tt_id_channel_operator": map[string]interface{}{
-			"string": ticket.IDChannelOperator,
-		},
-		"tt_description": map[string]interface{}{
-			"string": ticket.Description,
-		},
-		"tt_ts_start": map[string]interface{}{
-			"long": ticket.TTStartTimeTS,
-		},
-		"date_in_string": map[string]interface{}{
-			"string": ticket.TTStartTime,
-		},
-		"tt_problem_type": map[string]interface{}{
-			"string": ticket.TTClassification,
-		},
-		"tt_file_name": map[string]interface{}{
-			"string": ticket.FileName,
-		},
-		"tt_file": map[string]interface{}{
-			"string": ticket.File,
-		},
-		"tt_erth": map[string]interface{}{
-			"string": ticket.OperatorTTId,
-		},
-		"tt_ts": map[string]interface{}{
-			"long": ticket.EventTimestamp,
-		},
-		"tt_status": map[string]interface{}{
-			"string": ticket.TTStatus,
-		},
-		"tt_comment": map[string]interface{}{
-			"string": ticket.Comment,
-		},
-		"tt_user": map[string]interface{}{
-			"string": ticket.User,
-		},
+// Ждет backoff или завершения ctx, возвращает следующую задержку, не больше maxRetryBackoff
+func wait(ctx context.Context, backoff time.Duration) (time.Duration, error) {
+	select {
+	case <-ctx.Done():
+		return backoff, ctx.Err()
+	case <-time.After(backoff):
 	}
-	message, err := k.encodeAvro(m)
-	if err != nil {
-		return nil, fmt.Errorf("messageBroker.ticketToBinaryConverter: %w", err)
+	backoff *= 2
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
 	}
-	return message, nil
+	return backoff, nil
 }
 
-func (k *kafkaBroker) getScheme(registryURL string, schemeID uint32) (string, error) {
-	reqURL := fmt.Sprintf("%s%d", registryURL, schemeID)
-	req, err := http.NewRequest("GET", reqURL, nil)
-	if err != nil {
-		return "", fmt.Errorf("MessageBroker.getScheme: %w", err)
-	}
-	ctx, cancel := context.WithTimeout(req.Context(), time.Second*15)
-	defer cancel()
-	req = req.WithContext(ctx)
-	client := http.DefaultClient
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("MessageBroker.getScheme: %w", err)
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("MessageBroker.getScheme: %w", err)
-	}
-	scheme := struct {
-		Scheme string `json:"schema"`
-	}{}
-	err = json.Unmarshal(body, &scheme)
-	if err != nil {
-		return "", fmt.Errorf("MessageBroker.getScheme: %w", err)
+func header(message kafka.Message, key string) string {
+	for _, h := range message.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
 	}
-	log.Printf("Imported scheme with ID %d,  scheme: %+v", schemeID, scheme)
-	return scheme.Scheme, nil
+	return ""
 }
@@ -0,0 +1,155 @@
+package messageBroker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+var ErrBrokerClosed = errors.New("message broker is closed")
+
+type WriterConfig struct {
+	BatchSize    int           //сообщений в одной отправке
+	BatchTimeout time.Duration //ожидание заполнения пачки
+	QueueSize    int           //очередь асинхронной отправки на топик
+}
+
+type pending struct {
+	message  kafka.Message
+	callback func(err error)
+}
+
+// Writer и очередь асинхронной отправки топика, создаются при первой отправке и живут до Close
+type producer struct {
+	writer  *kafka.Writer
+	queue   chan pending
+	done    chan struct{} //закрыт в Close, новые сообщения не принимаются
+	stopped chan struct{} //закрыт после отправки остатка очереди
+}
+
+// Writer топика из пула, вызывается под k.mu
+func (k *kafkaBroker) producer(topic string) (*producer, error) {
+	if k.closed {
+		return nil, ErrBrokerClosed
+	}
+	p, ok := k.producers[topic]
+	if ok {
+		return p, nil
+	}
+	p = &producer{
+		writer:  k.newWriter(topic),
+		queue:   make(chan pending, k.writerConfig.QueueSize),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	k.producers[topic] = p
+	go k.runAsync(p)
+	return p, nil
+}
+
+func (k *kafkaBroker) write(ctx context.Context, topic string, messages ...kafka.Message) error {
+	k.mu.Lock()
+	p, err := k.producer(topic)
+	k.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return p.writer.WriteMessages(ctx, messages...)
+}
+
+// Асинхронная отправка: callback вызывается после подтверждения брокером или ошибки.
+// Ошибка возвращается, только если сообщение не поставлено в очередь
+func (k *kafkaBroker) writeAsync(ctx context.Context, topic string, message kafka.Message, callback func(err error)) error {
+	k.mu.Lock()
+	p, err := k.producer(topic)
+	if err != nil {
+		k.mu.Unlock()
+		return err
+	}
+	//Close не закроет очередь, пока есть отправители
+	k.sending.Add(1)
+	k.mu.Unlock()
+	defer k.sending.Done()
+	select {
+	case p.queue <- pending{message: message, callback: callback}:
+		return nil
+	case <-p.done:
+		return ErrBrokerClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Собирает из очереди пачки до BatchSize сообщений или до истечения BatchTimeout
+func (k *kafkaBroker) runAsync(p *producer) {
+	defer close(p.stopped)
+	batch := make([]pending, 0, k.writerConfig.BatchSize)
+	timer := time.NewTimer(k.writerConfig.BatchTimeout)
+	defer timer.Stop()
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		messages := make([]kafka.Message, len(batch))
+		for i := range batch {
+			messages[i] = batch[i].message
+		}
+		err := p.writer.WriteMessages(context.Background(), messages...)
+		if err != nil {
+			k.lg.Error("messageBroker.runAsync", zap.String("topic", p.writer.Topic), zap.Int("messages", len(batch)), zap.Error(err))
+		}
+		for i := range batch {
+			if batch[i].callback != nil {
+				batch[i].callback(err)
+			}
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case item, ok := <-p.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, item)
+			if len(batch) >= k.writerConfig.BatchSize {
+				flush()
+			}
+		case <-timer.C:
+			flush()
+			timer.Reset(k.writerConfig.BatchTimeout)
+		}
+	}
+}
+
+// Отправляет накопленные сообщения и закрывает writers. Новые сообщения после Close не принимаются
+func (k *kafkaBroker) Close() error {
+	k.mu.Lock()
+	if k.closed {
+		k.mu.Unlock()
+		return nil
+	}
+	k.closed = true
+	producers := k.producers
+	k.mu.Unlock()
+
+	for _, p := range producers {
+		close(p.done)
+	}
+	k.sending.Wait()
+	var closeErr error
+	for topic, p := range producers {
+		close(p.queue)
+		<-p.stopped
+		err := p.writer.Close()
+		if err != nil {
+			closeErr = fmt.Errorf("messageBroker.Close: topic %s: %w", topic, err)
+		}
+	}
+	return closeErr
+}
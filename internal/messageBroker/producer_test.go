@@ -0,0 +1,221 @@
+package messageBroker
+
+import (
+	"TController/internal/model"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Отправка с задержкой ответа брокера. Writer и соединение на каждое сообщение (как до пула) против
+// общего writer топика, который собирает в пачку сообщения параллельных отправителей
+func BenchmarkPushMessage(b *testing.B) {
+	ctx := context.Background()
+	const (
+		latency   = time.Millisecond
+		handshake = 3 * time.Millisecond
+	)
+	tickets := make([]*model.Ticket, 16)
+	for i := range tickets {
+		tickets[i] = &model.Ticket{MessageType: model.Create, CustomerInternalId: fmt.Sprintf("c%d", i), Description: "benchmark"}
+	}
+	var next uint32
+	ticket := func() *model.Ticket {
+		return tickets[atomic.AddUint32(&next, 1)%uint32(len(tickets))]
+	}
+
+	b.Run("per-message writer", func(b *testing.B) {
+		transport := &fakeTransport{latency: latency}
+		k := newTestBroker(transport, WriterConfig{BatchSize: 1})
+		defer k.Close()
+		codec := NewJSONCodec()
+		b.SetParallelism(16)
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				t := ticket()
+				value, err := codec.Encode(ctx, t)
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				writer := k.newWriter("requests")
+				writer.Transport = &connTransport{RoundTripper: transport, handshake: handshake}
+				err = writer.WriteMessages(ctx, kafka.Message{Key: []byte(t.Key()), Value: value})
+				writer.Close()
+				if err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+
+	for _, c := range benchmarkCodecs(b) {
+		codec := c.codec
+		b.Run("pooled batched writer/"+c.format, func(b *testing.B) {
+			transport := &connTransport{RoundTripper: &fakeTransport{latency: latency}, handshake: handshake}
+			k := newTestBroker(transport, WriterConfig{BatchSize: 100, BatchTimeout: time.Millisecond})
+			k.codecs = map[string]Codec{"requests": codec}
+			defer k.Close()
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					err := k.PushMessage(ctx, "requests", ticket())
+					if err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+type benchmarkCodec struct {
+	format string
+	codec  Codec
+}
+
+// Реестр схем нужен только для чтения сообщений, при записи используется схема writer
+func benchmarkCodecs(b *testing.B) []benchmarkCodec {
+	schema := ticketSchema(b, 1)
+	return []benchmarkCodec{
+		{format: FormatJSON, codec: NewJSONCodec()},
+		{format: FormatAvro, codec: NewAvroCodec(nil, schema, schema)},
+	}
+}
+
+// Кодирование запроса со всеми заполненными полями без отправки
+func BenchmarkEncode(b *testing.B) {
+	ctx := context.Background()
+	ticket := testTicket()
+	for _, c := range benchmarkCodecs(b) {
+		b.Run(c.format, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, err := c.codec.Encode(ctx, ticket)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestPushEventAsyncCallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		writeErr error
+	}{
+		{name: "acknowledged"},
+		{name: "write failed", writeErr: errors.New("broker is down")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := &fakeTransport{err: tt.writeErr}
+			k := newTestBroker(transport, WriterConfig{BatchSize: 10, BatchTimeout: 5 * time.Millisecond, QueueSize: 10})
+			defer k.Close()
+			const events = 3
+			results := make(chan error, events)
+			for i := 0; i < events; i++ {
+				err := k.PushEventAsync(context.Background(), "events", "c1", []byte(fmt.Sprintf(`{"n":%d}`, i)), func(err error) {
+					results <- err
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i := 0; i < events; i++ {
+				select {
+				case err := <-results:
+					if (err != nil) != (tt.writeErr != nil) {
+						t.Fatalf("callback err = %v, want %v", err, tt.writeErr)
+					}
+				case <-time.After(10 * time.Second):
+					t.Fatalf("callback %d was not called", i)
+				}
+			}
+			if tt.writeErr == nil && len(transport.sent()) != events {
+				t.Fatalf("sent %d messages, want %d", len(transport.sent()), events)
+			}
+		})
+	}
+}
+
+func TestCloseFlushesQueue(t *testing.T) {
+	transport := &fakeTransport{}
+	//Пачка не заполнится и не истечет до Close
+	k := newTestBroker(transport, WriterConfig{BatchSize: 100, BatchTimeout: 100 * time.Millisecond, QueueSize: 100})
+	const events = 5
+	var mu sync.Mutex
+	called := 0
+	for i := 0; i < events; i++ {
+		err := k.PushEventAsync(context.Background(), "events", "c1", []byte(fmt.Sprintf(`{"n":%d}`, i)), func(err error) {
+			if err != nil {
+				t.Error(err)
+			}
+			mu.Lock()
+			called++
+			mu.Unlock()
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := k.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if called != events || len(transport.sent()) != events {
+		t.Fatalf("after Close: %d callbacks, %d messages sent, want %d", called, len(transport.sent()), events)
+	}
+	err = k.PushEventAsync(context.Background(), "events", "c1", []byte(`{}`), nil)
+	if !errors.Is(err, ErrBrokerClosed) {
+		t.Fatalf("push after Close: err = %v, want %v", err, ErrBrokerClosed)
+	}
+	err = k.PushEvent(context.Background(), "events", "c1", []byte(`{}`))
+	if !errors.Is(err, ErrBrokerClosed) {
+		t.Fatalf("push after Close: err = %v, want %v", err, ErrBrokerClosed)
+	}
+}
+
+func TestPushEventKeyAndHeaders(t *testing.T) {
+	transport := &fakeTransport{}
+	k := newTestBroker(transport, WriterConfig{})
+	defer k.Close()
+	ctx := model.WithSource(model.WithCorrelationID(context.Background(), "corr-1"), "sber")
+	err := k.PushEvent(ctx, "events", "c1", []byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	err = k.PushEventAsync(ctx, "events", "c1", []byte(`{}`), func(err error) { done <- err })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	sent := transport.sent()
+	if len(sent) != 2 {
+		t.Fatalf("sent %d messages", len(sent))
+	}
+	for _, message := range sent {
+		if string(message.Key) != "c1" ||
+			header(message, HeaderCorrelationID) != "corr-1" ||
+			header(message, HeaderSource) != "sber" ||
+			header(message, HeaderVersion) != Version {
+			t.Fatalf("message key %q, headers %v", message.Key, message.Headers)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package messageBroker
+
+import (
+	"TController/internal/model"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var ErrProtobuf = errors.New("malformed protobuf message")
+
+// Типы полей в формате protobuf
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// Сообщение Ticket из ticket.proto. Кодируется вручную: в сообщении только строки и int64,
+// генератор и библиотека protobuf для этого не нужны
+type protobufCodec struct{}
+
+func NewProtobufCodec() Codec {
+	return &protobufCodec{}
+}
+
+func (c *protobufCodec) Encode(ctx context.Context, ticket *model.Ticket) ([]byte, error) {
+	data := make([]byte, 0, 256)
+	data = appendString(data, 1, string(ticket.MessageType))
+	data = appendString(data, 2, ticket.IDChannelOperatorForBilling)
+	data = appendString(data, 3, ticket.CustomerInternalId)
+	data = appendString(data, 4, ticket.IDChannelOperator)
+	data = appendString(data, 5, ticket.Description)
+	data = appendInt64(data, 6, ticket.TTStartTimeTS)
+	data = appendString(data, 7, ticket.TTStartTime)
+	data = appendString(data, 8, ticket.TTClassification)
+	data = appendString(data, 9, ticket.FileName)
+	data = appendString(data, 10, ticket.File)
+	data = appendString(data, 11, ticket.OperatorTTId)
+	data = appendInt64(data, 12, ticket.EventTimestamp)
+	data = appendString(data, 13, ticket.TimeStampString)
+	data = appendString(data, 14, ticket.TTStatus)
+	data = appendString(data, 15, ticket.Comment)
+	data = appendString(data, 16, ticket.User)
+	return data, nil
+}
+
+// Неизвестные поля пропускаются, как требует protobuf
+func (c *protobufCodec) Decode(ctx context.Context, data []byte) (*model.Ticket, error) {
+	ticket := &model.Ticket{}
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("protobufCodec.Decode: %w: bad field key", ErrProtobuf)
+		}
+		data = data[n:]
+		number, wireType := key>>3, key&7
+		var value uint64
+		var bytes []byte
+		switch wireType {
+		case wireVarint:
+			value, n = binary.Uvarint(data)
+			if n <= 0 {
+				return nil, fmt.Errorf("protobufCodec.Decode: %w: field %d: bad varint", ErrProtobuf, number)
+			}
+			data = data[n:]
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || length > uint64(len(data)-n) {
+				return nil, fmt.Errorf("protobufCodec.Decode: %w: field %d: bad length", ErrProtobuf, number)
+			}
+			bytes = data[n : n+int(length)]
+			data = data[n+int(length):]
+		case wireFixed64, wireFixed32:
+			size := 8
+			if wireType == wireFixed32 {
+				size = 4
+			}
+			if len(data) < size {
+				return nil, fmt.Errorf("protobufCodec.Decode: %w: field %d: truncated", ErrProtobuf, number)
+			}
+			data = data[size:]
+			continue
+		default:
+			return nil, fmt.Errorf("protobufCodec.Decode: %w: field %d: wire type %d", ErrProtobuf, number, wireType)
+		}
+		if wireType == wireVarint {
+			switch number {
+			case 6:
+				ticket.TTStartTimeTS = int64(value)
+			case 12:
+				ticket.EventTimestamp = int64(value)
+			}
+			continue
+		}
+		switch number {
+		case 1:
+			ticket.MessageType = parseRequestType(string(bytes))
+		case 2:
+			ticket.IDChannelOperatorForBilling = string(bytes)
+		case 3:
+			ticket.CustomerInternalId = string(bytes)
+		case 4:
+			ticket.IDChannelOperator = string(bytes)
+		case 5:
+			ticket.Description = string(bytes)
+		case 7:
+			ticket.TTStartTime = string(bytes)
+		case 8:
+			ticket.TTClassification = string(bytes)
+		case 9:
+			ticket.FileName = string(bytes)
+		case 10:
+			ticket.File = string(bytes)
+		case 11:
+			ticket.OperatorTTId = string(bytes)
+		case 13:
+			ticket.TimeStampString = string(bytes)
+		case 14:
+			ticket.TTStatus = string(bytes)
+		case 15:
+			ticket.Comment = string(bytes)
+		case 16:
+			ticket.User = string(bytes)
+		}
+	}
+	//В proto3 пустой тип запроса не передается, как и в avro он считается комментарием
+	if ticket.MessageType == "" {
+		ticket.MessageType = model.Note
+	}
+	return ticket, nil
+}
+
+// Пустые значения в proto3 не передаются
+func appendString(data []byte, number uint64, value string) []byte {
+	if value == "" {
+		return data
+	}
+	data = appendVarint(data, number<<3|wireBytes)
+	data = appendVarint(data, uint64(len(value)))
+	return append(data, value...)
+}
+
+func appendInt64(data []byte, number uint64, value int64) []byte {
+	if value == 0 {
+		return data
+	}
+	data = appendVarint(data, number<<3|wireVarint)
+	return appendVarint(data, uint64(value))
+}
+
+func appendVarint(data []byte, value uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], value)
+	return append(data, buf[:n]...)
+}
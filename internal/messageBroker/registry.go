@@ -0,0 +1,421 @@
+package messageBroker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/linkedin/goavro"
+	"go.uber.org/zap"
+)
+
+var ErrSchemaRegistry = errors.New("schema registry error")
+var ErrWireFormat = errors.New("message is not in schema registry wire format")
+
+const registryContentType = "application/vnd.schemaregistry.v1+json"
+
+// Реестр недоступен или ответил 5xx: сообщение разбирается повторно, а не уходит в dead-letter топик.
+// Ответы 4xx (например, 40403 - схема не найдена) не повторяются
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+func retryable(err error) bool {
+	var retryableError *RetryableError
+	return errors.As(err, &retryableError)
+}
+
+// Клиент Confluent Schema Registry. Схемы по ID неизменны и кэшируются на все время работы
+type SchemaRegistry interface {
+	//Схема по ID из сообщения (байты 1-4)
+	GetByID(ctx context.Context, id uint32) (*Schema, error)
+	//Последняя версия схемы в subject
+	GetLatest(ctx context.Context, subject string) (*Schema, error)
+	//Регистрирует схему в subject, если такая схема уже есть - возвращает ее
+	Register(ctx context.Context, subject string, schema string) (*Schema, error)
+}
+
+type Schema struct {
+	ID      uint32
+	Subject string
+	Version int
+	Schema  string
+	Codec   *goavro.Codec
+	fields  map[string][]string //поля записи верхнего уровня и их типы, у union - несколько
+}
+
+type schemaRegistry struct {
+	url    string
+	client *http.Client
+	mu     sync.Mutex
+	byID   map[uint32]*Schema
+	lg     *zap.Logger
+}
+
+// URL реестра без пути, для совместимости принимается и старый вид .../schemas/ids/
+func NewSchemaRegistry(registryURL string, lg *zap.Logger) SchemaRegistry {
+	registryURL = strings.TrimSuffix(strings.TrimRight(registryURL, "/"), "/schemas/ids")
+	return &schemaRegistry{
+		url:    registryURL,
+		client: &http.Client{Timeout: 15 * time.Second},
+		byID:   make(map[uint32]*Schema),
+		lg:     lg,
+	}
+}
+
+func (r *schemaRegistry) GetByID(ctx context.Context, id uint32) (*Schema, error) {
+	r.mu.Lock()
+	schema, ok := r.byID[id]
+	r.mu.Unlock()
+	if ok {
+		return schema, nil
+	}
+	response := struct {
+		Schema string `json:"schema"`
+	}{}
+	err := r.do(ctx, http.MethodGet, fmt.Sprintf("/schemas/ids/%d", id), nil, &response)
+	if err != nil {
+		return nil, fmt.Errorf("schemaRegistry.GetByID: %w", err)
+	}
+	schema, err = newSchema(id, response.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("schemaRegistry.GetByID: %w", err)
+	}
+	r.lg.Info("Imported schema", zap.Uint32("id", id))
+	return r.store(schema), nil
+}
+
+func (r *schemaRegistry) GetLatest(ctx context.Context, subject string) (*Schema, error) {
+	response := struct {
+		Subject string `json:"subject"`
+		Version int    `json:"version"`
+		ID      uint32 `json:"id"`
+		Schema  string `json:"schema"`
+	}{}
+	err := r.do(ctx, http.MethodGet, fmt.Sprintf("/subjects/%s/versions/latest", url.PathEscape(subject)), nil, &response)
+	if err != nil {
+		return nil, fmt.Errorf("schemaRegistry.GetLatest: %w", err)
+	}
+	schema, err := newSchema(response.ID, response.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("schemaRegistry.GetLatest: %w", err)
+	}
+	schema.Subject = response.Subject
+	schema.Version = response.Version
+	r.lg.Info("Imported schema", zap.String("subject", subject), zap.Int("version", schema.Version), zap.Uint32("id", schema.ID))
+	return r.store(schema), nil
+}
+
+func (r *schemaRegistry) Register(ctx context.Context, subject string, schemaStr string) (*Schema, error) {
+	//Схема проверяется до отправки, чтобы не регистрировать то, что нельзя разобрать
+	schema, err := newSchema(0, schemaStr)
+	if err != nil {
+		return nil, fmt.Errorf("schemaRegistry.Register: %w", err)
+	}
+	body, err := json.Marshal(map[string]string{"schema": schemaStr})
+	if err != nil {
+		return nil, fmt.Errorf("schemaRegistry.Register: %w", err)
+	}
+	response := struct {
+		ID uint32 `json:"id"`
+	}{}
+	err = r.do(ctx, http.MethodPost, fmt.Sprintf("/subjects/%s/versions", url.PathEscape(subject)), body, &response)
+	if err != nil {
+		return nil, fmt.Errorf("schemaRegistry.Register: %w", err)
+	}
+	schema.ID = response.ID
+	schema.Subject = subject
+	r.lg.Info("Registered schema", zap.String("subject", subject), zap.Uint32("id", schema.ID))
+	return r.store(schema), nil
+}
+
+// Схема с тем же ID уже могла быть загружена другим запросом, сохраняется первая
+func (r *schemaRegistry) store(schema *Schema) *Schema {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.byID[schema.ID]
+	if ok {
+		if stored.Subject == "" {
+			stored.Subject = schema.Subject
+			stored.Version = schema.Version
+		}
+		return stored
+	}
+	r.byID[schema.ID] = schema
+	return schema
+}
+
+func (r *schemaRegistry) do(ctx context.Context, method string, path string, body []byte, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, r.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", registryContentType)
+	if body != nil {
+		req.Header.Set("Content-Type", registryContentType)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return &RetryableError{Err: err}
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return &RetryableError{Err: err}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		registryError := struct {
+			ErrorCode int    `json:"error_code"`
+			Message   string `json:"message"`
+		}{}
+		_ = json.Unmarshal(respBody, &registryError)
+		err = fmt.Errorf("%w: %s %s: %d %s", ErrSchemaRegistry, method, path, registryError.ErrorCode, registryError.Message)
+		if resp.StatusCode >= 500 {
+			return &RetryableError{Err: err}
+		}
+		return err
+	}
+	return json.Unmarshal(respBody, result)
+}
+
+func newSchema(id uint32, schemaStr string) (*Schema, error) {
+	codec, err := goavro.NewCodec(schemaStr)
+	if err != nil {
+		return nil, err
+	}
+	schema := &Schema{ID: id, Schema: schemaStr, Codec: codec, fields: make(map[string][]string)}
+	record := struct {
+		Fields []struct {
+			Name string          `json:"name"`
+			Type json.RawMessage `json:"type"`
+		} `json:"fields"`
+	}{}
+	//Примитивные типы задаются строкой, полей у них нет
+	if json.Unmarshal([]byte(schemaStr), &record) == nil {
+		for _, field := range record.Fields {
+			schema.fields[field.Name] = typeNames(field.Type)
+		}
+	}
+	return schema, nil
+}
+
+// Имена типов поля: "string", ["null", "string"], {"type": "map", ...}
+func typeNames(raw json.RawMessage) []string {
+	var name string
+	if json.Unmarshal(raw, &name) == nil {
+		return []string{name}
+	}
+	var union []json.RawMessage
+	if json.Unmarshal(raw, &union) == nil {
+		names := make([]string, 0, len(union))
+		for _, branch := range union {
+			names = append(names, typeNames(branch)...)
+		}
+		return names
+	}
+	named := struct {
+		Type      string `json:"type"`
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	}{}
+	if json.Unmarshal(raw, &named) != nil || named.Type == "" {
+		return nil
+	}
+	//Ветка union именованного типа в goavro называется полным именем типа
+	switch named.Type {
+	case "record", "enum", "fixed":
+		if named.Namespace != "" && !strings.Contains(named.Name, ".") {
+			return []string{named.Namespace + "." + named.Name}
+		}
+		return []string{named.Name}
+	}
+	return []string{named.Type}
+}
+
+// Приведение данных, записанных схемой writer, к схеме чтения s: поля, которых нет в s, отбрасываются,
+// отсутствующие в writer поля получают значения по умолчанию из s. Значение поля приводится к типу
+// поля в s: union и не-union, int -> long -> float -> double
+func (s *Schema) Resolve(writer *Schema, native interface{}) (interface{}, error) {
+	if writer.ID == s.ID {
+		return native, nil
+	}
+	record, ok := native.(map[string]interface{})
+	if !ok {
+		return native, nil
+	}
+	projected := make(map[string]interface{}, len(s.fields))
+	mappingError := &MappingError{}
+	for name, value := range record {
+		types, ok := s.fields[name]
+		if !ok {
+			continue
+		}
+		//У union goavro передает значение как {"тип": значение}
+		if len(writer.fields[name]) > 1 {
+			value = unwrapUnion(value)
+		}
+		resolved, err := resolveValue(types, value)
+		if err != nil {
+			mappingError.Fields = append(mappingError.Fields, &FieldError{Field: name, Err: err})
+			continue
+		}
+		projected[name] = resolved
+	}
+	if len(mappingError.Fields) > 0 {
+		return nil, fmt.Errorf("Schema.Resolve: writer schema %d, reader schema %d: %w", writer.ID, s.ID, mappingError)
+	}
+	//goavro заполняет отсутствующие поля значениями по умолчанию только при кодировании
+	binary, err := s.Codec.BinaryFromNative(nil, projected)
+	if err != nil {
+		return nil, fmt.Errorf("Schema.Resolve: writer schema %d, reader schema %d: %w", writer.ID, s.ID, err)
+	}
+	resolved, _, err := s.Codec.NativeFromBinary(binary)
+	if err != nil {
+		return nil, fmt.Errorf("Schema.Resolve: writer schema %d, reader schema %d: %w", writer.ID, s.ID, err)
+	}
+	return resolved, nil
+}
+
+// Значение в native-форме goavro для поля с типами types: первая ветка, в которую значение
+// переходит по правилам разрешения схем avro
+func resolveValue(types []string, value interface{}) (interface{}, error) {
+	for _, avroType := range types {
+		resolved, ok := promote(avroType, value)
+		if !ok {
+			continue
+		}
+		if len(types) == 1 || resolved == nil {
+			return resolved, nil
+		}
+		return map[string]interface{}{avroType: resolved}, nil
+	}
+	return nil, fmt.Errorf("%w: %T does not fit %v", ErrFieldType, value, types)
+}
+
+func promote(avroType string, value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case nil:
+		return nil, avroType == "null"
+	case bool:
+		return v, avroType == "boolean"
+	case string:
+		switch avroType {
+		case "string":
+			return v, true
+		case "bytes":
+			return []byte(v), true
+		}
+		//enum
+		return v, !primitive(avroType)
+	case []byte:
+		switch avroType {
+		case "bytes":
+			return v, true
+		case "string":
+			return string(v), true
+		}
+		//fixed
+		return v, !primitive(avroType)
+	case int32:
+		switch avroType {
+		case "int":
+			return v, true
+		case "long":
+			return int64(v), true
+		case "float":
+			return float32(v), true
+		case "double":
+			return float64(v), true
+		}
+	case int64:
+		switch avroType {
+		case "long":
+			return v, true
+		case "float":
+			return float32(v), true
+		case "double":
+			return float64(v), true
+		}
+	case float32:
+		switch avroType {
+		case "float":
+			return v, true
+		case "double":
+			return float64(v), true
+		}
+	case float64:
+		return v, avroType == "double"
+	default:
+		//Составные и именованные типы передаются как есть, их проверяет кодек схемы чтения
+		return v, !primitive(avroType)
+	}
+	return nil, false
+}
+
+func primitive(avroType string) bool {
+	switch avroType {
+	case "null", "boolean", "int", "long", "float", "double", "bytes", "string":
+		return true
+	}
+	return false
+}
+
+// Схемы брокера: ID задан - схема по ID, иначе последняя версия subject
+type SchemaConfig struct {
+	RegistryURL  string
+	InID         uint32 //схема отправляемых запросов
+	InSubject    string
+	InSchemaFile string //если задан - схема регистрируется в InSubject и используется для отправки
+	OutID        uint32 //схема чтения ответов систем
+	OutSubject   string
+	Formats      []string //topic=format: avro (по умолчанию), json, protobuf
+}
+
+func (k *kafkaBroker) loadSchemas(ctx context.Context, config SchemaConfig) (err error) {
+	switch {
+	case config.InSchemaFile != "":
+		if config.InSubject == "" {
+			return fmt.Errorf("schema file %s: subject is not set", config.InSchemaFile)
+		}
+		schemaStr, err := ioutil.ReadFile(config.InSchemaFile)
+		if err != nil {
+			return err
+		}
+		k.schemaIN, err = k.registry.Register(ctx, config.InSubject, string(schemaStr))
+		if err != nil {
+			return err
+		}
+	case config.InID != 0:
+		k.schemaIN, err = k.registry.GetByID(ctx, config.InID)
+		if err != nil {
+			return err
+		}
+	default:
+		k.schemaIN, err = k.registry.GetLatest(ctx, config.InSubject)
+		if err != nil {
+			return err
+		}
+	}
+	if config.OutID != 0 {
+		k.schemaOUT, err = k.registry.GetByID(ctx, config.OutID)
+	} else {
+		k.schemaOUT, err = k.registry.GetLatest(ctx, config.OutSubject)
+	}
+	return err
+}
@@ -0,0 +1,211 @@
+package messageBroker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// Схема записи с полями name:type, тип - JSON
+func recordSchema(t testing.TB, id uint32, fields ...string) *Schema {
+	t.Helper()
+	definitions := make([]string, len(fields))
+	for i, field := range fields {
+		parts := strings.SplitN(field, ":", 2)
+		definitions[i] = fmt.Sprintf(`{"name":%q,"type":%s}`, parts[0], parts[1])
+	}
+	schema, err := newSchema(id, `{"type":"record","name":"TT","fields":[`+strings.Join(definitions, ",")+`]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name   string
+		writer []string
+		reader []string
+		native map[string]interface{}
+		want   map[string]interface{}
+		err    error
+	}{
+		{
+			name:   "non-union to union",
+			writer: []string{`tt_erth:"string"`},
+			reader: []string{`tt_erth:["null","string"]`},
+			native: map[string]interface{}{"tt_erth": "TT-1"},
+			want:   map[string]interface{}{"tt_erth": map[string]interface{}{"string": "TT-1"}},
+		},
+		{
+			name:   "union to non-union",
+			writer: []string{`tt_erth:["null","string"]`},
+			reader: []string{`tt_erth:"string"`},
+			native: map[string]interface{}{"tt_erth": map[string]interface{}{"string": "TT-1"}},
+			want:   map[string]interface{}{"tt_erth": "TT-1"},
+		},
+		{
+			name:   "null to union",
+			writer: []string{`tt_erth:["null","string"]`},
+			reader: []string{`tt_erth:["null","string"]`},
+			native: map[string]interface{}{"tt_erth": nil},
+			want:   map[string]interface{}{"tt_erth": nil},
+		},
+		{
+			name:   "null to non-union",
+			writer: []string{`tt_erth:["null","string"]`},
+			reader: []string{`tt_erth:"string"`},
+			native: map[string]interface{}{"tt_erth": nil},
+			err:    ErrAvroMapping,
+		},
+		{
+			name:   "int to long",
+			writer: []string{`tt_ts:"int"`},
+			reader: []string{`tt_ts:"long"`},
+			native: map[string]interface{}{"tt_ts": int32(5)},
+			want:   map[string]interface{}{"tt_ts": int64(5)},
+		},
+		{
+			name:   "int to long union",
+			writer: []string{`tt_ts:"int"`},
+			reader: []string{`tt_ts:["null","long"]`},
+			native: map[string]interface{}{"tt_ts": int32(5)},
+			want:   map[string]interface{}{"tt_ts": map[string]interface{}{"long": int64(5)}},
+		},
+		{
+			name:   "long to int",
+			writer: []string{`tt_ts:"long"`},
+			reader: []string{`tt_ts:"int"`},
+			native: map[string]interface{}{"tt_ts": int64(5)},
+			err:    ErrAvroMapping,
+		},
+		{
+			name:   "added field gets default",
+			writer: []string{`tt_erth:"string"`},
+			reader: []string{`tt_erth:"string"`, `tt_user:"string","default":"none"`},
+			native: map[string]interface{}{"tt_erth": "TT-1"},
+			want:   map[string]interface{}{"tt_erth": "TT-1", "tt_user": "none"},
+		},
+		{
+			name:   "removed field dropped",
+			writer: []string{`tt_erth:"string"`, `tt_user:"string"`},
+			reader: []string{`tt_erth:"string"`},
+			native: map[string]interface{}{"tt_erth": "TT-1", "tt_user": "ivanov"},
+			want:   map[string]interface{}{"tt_erth": "TT-1"},
+		},
+		{
+			name:   "enum to union",
+			writer: []string{`tt_status:{"type":"enum","name":"Status","symbols":["OPEN","DONE"]}`},
+			reader: []string{`tt_status:["null",{"type":"enum","name":"Status","namespace":"tt","symbols":["OPEN","DONE"]}]`},
+			native: map[string]interface{}{"tt_status": "DONE"},
+			want:   map[string]interface{}{"tt_status": map[string]interface{}{"tt.Status": "DONE"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writer := recordSchema(t, 1, tt.writer...)
+			reader := recordSchema(t, 2, tt.reader...)
+			//Данные в native-форме, как их отдает кодек writer
+			binary, err := writer.Codec.BinaryFromNative(nil, tt.native)
+			if err != nil {
+				t.Fatal(err)
+			}
+			native, _, err := writer.Codec.NativeFromBinary(binary)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := reader.Resolve(writer, native)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				var mappingError *MappingError
+				if !errors.As(err, &mappingError) || len(mappingError.Fields) != 1 || !errors.Is(mappingError.Fields[0], ErrFieldType) {
+					t.Fatalf("err = %v, want *MappingError with one field", err)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("resolved = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Реестр, который отдает схемы по ID или отвечает status; status < 0 - реестр недоступен
+func newTestRegistry(t *testing.T, status int, schemas map[uint32]*Schema) SchemaRegistry {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(`{"error_code":50001,"message":"unavailable"}`))
+			return
+		}
+		var id uint32
+		_, err := fmt.Sscanf(r.URL.Path, "/schemas/ids/%d", &id)
+		schema, ok := schemas[id]
+		if err != nil || !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error_code":40403,"message":"Schema not found"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"schema": schema.Schema})
+	}))
+	t.Cleanup(server.Close)
+	if status < 0 {
+		server.Close()
+	}
+	return NewSchemaRegistry(server.URL, zap.NewNop())
+}
+
+func TestAvroDecodeErrors(t *testing.T) {
+	writer := recordSchema(t, 1, `tt_erth:"string"`, `tt_ts:"int"`)
+	reader := recordSchema(t, 2, `tt_erth:["null","string"]`, `tt_ts:"long"`, `tt_user:"string","default":"none"`)
+	message := []byte{0, 0, 0, 0, 1}
+	message, err := writer.Codec.BinaryFromNative(message, map[string]interface{}{"tt_erth": "TT-1", "tt_ts": int32(5)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown := append([]byte{0, 0, 0, 0, 9}, message[5:]...)
+	tests := []struct {
+		name      string
+		status    int
+		data      []byte
+		fail      bool
+		retryable bool
+		err       error
+	}{
+		{name: "resolved to reader schema", status: http.StatusOK, data: message},
+		{name: "registry unavailable", status: http.StatusServiceUnavailable, data: message, fail: true, retryable: true, err: ErrSchemaRegistry},
+		{name: "registry unreachable", status: -1, data: message, fail: true, retryable: true},
+		{name: "unknown schema id", status: http.StatusOK, data: unknown, fail: true, err: ErrSchemaRegistry},
+		{name: "wire format", status: http.StatusServiceUnavailable, data: []byte("{}"), fail: true, err: ErrWireFormat},
+		{name: "cannot decode", status: http.StatusOK, data: message[:6], fail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codec := NewAvroCodec(newTestRegistry(t, tt.status, map[uint32]*Schema{1: writer}), nil, reader)
+			ticket, err := codec.Decode(context.Background(), tt.data)
+			if (err != nil) != tt.fail || (tt.err != nil && !errors.Is(err, tt.err)) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if retryable(err) != tt.retryable {
+				t.Fatalf("retryable(%v) = %v, want %v", err, !tt.retryable, tt.retryable)
+			}
+			if tt.fail {
+				return
+			}
+			if ticket.OperatorTTId != "TT-1" || ticket.EventTimestamp != 5 || ticket.User != "none" {
+				t.Fatalf("ticket %+v", ticket)
+			}
+		})
+	}
+}
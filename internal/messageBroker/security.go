@@ -0,0 +1,95 @@
+package messageBroker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+var ErrUnknownMechanism = errors.New("unknown SASL mechanism")
+
+// Механизмы SASL
+const (
+	MechanismNone        = "NONE"
+	MechanismPlain       = "PLAIN"
+	MechanismScramSHA256 = "SCRAM-SHA-256"
+	MechanismScramSHA512 = "SCRAM-SHA-512"
+)
+
+// Подключение к кластеру. Пароль и ключи только из переменных окружения или файлов
+type ConnectionConfig struct {
+	Brokers       []string //адреса для первоначального подключения host:port
+	TLS           bool
+	CAFile        string //сертификат CA, пустой - системные корневые сертификаты
+	CertFile      string //клиентский сертификат и ключ для mTLS
+	KeyFile       string
+	SkipVerify    bool
+	SASLMechanism string //пустой - PLAIN, если задан пользователь, иначе без SASL
+	User          string
+	Password      string
+	PasswordFile  string //если задан, пароль читается из файла
+}
+
+func (c *ConnectionConfig) tlsConfig() (*tls.Config, error) {
+	if !c.TLS {
+		return nil, nil
+	}
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.SkipVerify,
+	}
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CAFile)
+		}
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func (c *ConnectionConfig) mechanism() (sasl.Mechanism, error) {
+	password := c.Password
+	if c.PasswordFile != "" {
+		data, err := ioutil.ReadFile(c.PasswordFile)
+		if err != nil {
+			return nil, err
+		}
+		password = strings.TrimRight(string(data), "\r\n")
+	}
+	name := strings.ToUpper(c.SASLMechanism)
+	if name == "" {
+		name = MechanismNone
+		if c.User != "" {
+			name = MechanismPlain
+		}
+	}
+	switch name {
+	case MechanismNone:
+		return nil, nil
+	case MechanismPlain:
+		return plain.Mechanism{Username: c.User, Password: password}, nil
+	case MechanismScramSHA256:
+		return scram.Mechanism(scram.SHA256, c.User, password)
+	case MechanismScramSHA512:
+		return scram.Mechanism(scram.SHA512, c.User, password)
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownMechanism, c.SASLMechanism)
+}
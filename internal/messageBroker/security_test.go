@@ -0,0 +1,238 @@
+package messageBroker
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	tls  tls.Certificate
+}
+
+// Сертификат, подписанный parent; parent == nil - самоподписанный CA
+func newTestCert(t *testing.T, name string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, tls: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}}
+}
+
+// Сертификат и ключ в PEM-файлах dir/name.crt, dir/name.key
+func (c *testCert) write(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// TLS-сервер на 127.0.0.1: после рукопожатия отправляет один байт и закрывает соединение
+func startTLSServer(t *testing.T, server *testCert, clientCAs *x509.CertPool, clientAuth tls.ClientAuthType) string {
+	t.Helper()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{server.tls},
+		ClientCAs:    clientCAs,
+		ClientAuth:   clientAuth,
+		MinVersion:   tls.VersionTLS12,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if conn.(*tls.Conn).Handshake() == nil {
+					_, _ = conn.Write([]byte{1})
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newTestCert(t, "test CA", nil, x509.ExtKeyUsageAny)
+	caFile, _ := ca.write(t, dir, "ca")
+	server := newTestCert(t, "broker", ca, x509.ExtKeyUsageServerAuth)
+	client := newTestCert(t, "controller", ca, x509.ExtKeyUsageClientAuth)
+	certFile, keyFile := client.write(t, dir, "client")
+	notPEM := filepath.Join(dir, "not.pem")
+	err = ioutil.WriteFile(notPEM, []byte("not a certificate"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	tlsAddr := startTLSServer(t, server, nil, tls.NoClientCert)
+	mtlsAddr := startTLSServer(t, server, clientCAs, tls.RequireAndVerifyClientCert)
+
+	tests := []struct {
+		name      string
+		config    ConnectionConfig
+		addr      string
+		configErr bool
+		dialErr   bool
+	}{
+		{name: "ca file", config: ConnectionConfig{TLS: true, CAFile: caFile}, addr: tlsAddr},
+		{name: "system roots do not trust test CA", config: ConnectionConfig{TLS: true}, addr: tlsAddr, dialErr: true},
+		{name: "skip verify", config: ConnectionConfig{TLS: true, SkipVerify: true}, addr: tlsAddr},
+		{name: "mtls", config: ConnectionConfig{TLS: true, CAFile: caFile, CertFile: certFile, KeyFile: keyFile}, addr: mtlsAddr},
+		{name: "mtls without client certificate", config: ConnectionConfig{TLS: true, CAFile: caFile}, addr: mtlsAddr, dialErr: true},
+		{name: "missing ca file", config: ConnectionConfig{TLS: true, CAFile: filepath.Join(dir, "missing.pem")}, configErr: true},
+		{name: "ca file without certificates", config: ConnectionConfig{TLS: true, CAFile: notPEM}, configErr: true},
+		{name: "key without certificate", config: ConnectionConfig{TLS: true, KeyFile: keyFile}, configErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := tt.config.tlsConfig()
+			if (err != nil) != tt.configErr {
+				t.Fatalf("tlsConfig err = %v", err)
+			}
+			if tt.configErr {
+				return
+			}
+			err = dialTLS(tt.addr, config)
+			if (err != nil) != tt.dialErr {
+				t.Fatalf("dial err = %v, want error: %v", err, tt.dialErr)
+			}
+		})
+	}
+
+	config, err := (&ConnectionConfig{CAFile: caFile}).tlsConfig()
+	if config != nil || err != nil {
+		t.Fatalf("TLS disabled: config = %v, err = %v", config, err)
+	}
+}
+
+// Рукопожатие в TLS 1.3 завершается на клиенте до проверки его сертификата сервером,
+// поэтому соединение считается установленным только после ответа сервера
+func dialTLS(addr string, config *tls.Config) error {
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", addr, config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	return err
+}
+
+func TestMechanism(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sasl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	passwordFile := filepath.Join(dir, "password")
+	err = ioutil.WriteFile(passwordFile, []byte("from-file\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		config   ConnectionConfig
+		want     string //имя механизма, пустое - без SASL
+		password string //для PLAIN
+		err      error
+	}{
+		{name: "none", config: ConnectionConfig{SASLMechanism: MechanismNone, User: "user"}},
+		{name: "default without user"},
+		{name: "default with user", config: ConnectionConfig{User: "user", Password: "secret"}, want: MechanismPlain, password: "secret"},
+		{name: "plain", config: ConnectionConfig{SASLMechanism: "plain", User: "user", Password: "secret"}, want: MechanismPlain, password: "secret"},
+		{name: "password file", config: ConnectionConfig{SASLMechanism: MechanismPlain, User: "user", Password: "ignored", PasswordFile: passwordFile}, want: MechanismPlain, password: "from-file"},
+		{name: "scram sha 256", config: ConnectionConfig{SASLMechanism: "scram-sha-256", User: "user", Password: "secret"}, want: MechanismScramSHA256},
+		{name: "scram sha 512", config: ConnectionConfig{SASLMechanism: MechanismScramSHA512, User: "user", Password: "secret"}, want: MechanismScramSHA512},
+		{name: "unknown", config: ConnectionConfig{SASLMechanism: "GSSAPI", User: "user"}, err: ErrUnknownMechanism},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mechanism, err := tt.config.mechanism()
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if tt.want == "" {
+				if mechanism != nil {
+					t.Fatalf("mechanism = %v, want none", mechanism.Name())
+				}
+				return
+			}
+			if mechanism == nil || mechanism.Name() != tt.want {
+				t.Fatalf("mechanism = %v, want %s", mechanism, tt.want)
+			}
+			if p, ok := mechanism.(plain.Mechanism); ok && (p.Username != "user" || p.Password != tt.password) {
+				t.Fatalf("plain credentials = %q/%q", p.Username, p.Password)
+			}
+		})
+	}
+
+	_, err = (&ConnectionConfig{User: "user", PasswordFile: filepath.Join(dir, "missing")}).mechanism()
+	if err == nil {
+		t.Fatal("expected error for missing password file")
+	}
+}
@@ -0,0 +1,118 @@
+package messageBroker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/segmentio/kafka-go/protocol/produce"
+	"go.uber.org/zap"
+)
+
+// Брокер в памяти: отвечает на запросы метаданных и записи, сохраняет отправленные сообщения
+type fakeTransport struct {
+	mu       sync.Mutex
+	err      error         //ошибка записи
+	failures int           //запросов записи с ошибкой err, 0 - все
+	latency  time.Duration //задержка ответа на запрос
+	produces int           //запросов записи
+	messages []kafka.Message
+}
+
+func (f *fakeTransport) RoundTrip(ctx context.Context, addr net.Addr, request kafka.Request) (protocol.Message, error) {
+	if f.latency > 0 {
+		time.Sleep(f.latency)
+	}
+	switch request := request.(type) {
+	case *metadata.Request:
+		response := &metadata.Response{Brokers: []metadata.ResponseBroker{{NodeID: 0, Host: "localhost", Port: 9092}}}
+		for _, topic := range request.TopicNames {
+			response.Topics = append(response.Topics, metadata.ResponseTopic{
+				Name:       topic,
+				Partitions: []metadata.ResponsePartition{{PartitionIndex: 0}, {PartitionIndex: 1}},
+			})
+		}
+		return response, nil
+	case *produce.Request:
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.produces++
+		if f.err != nil && (f.failures == 0 || f.produces <= f.failures) {
+			return nil, f.err
+		}
+		response := &produce.Response{}
+		for _, topic := range request.Topics {
+			responseTopic := produce.ResponseTopic{Topic: topic.Topic}
+			for _, partition := range topic.Partitions {
+				err := f.read(topic.Topic, int(partition.Partition), partition.RecordSet.Records)
+				if err != nil {
+					return nil, err
+				}
+				responseTopic.Partitions = append(responseTopic.Partitions, produce.ResponsePartition{Partition: partition.Partition})
+			}
+			response.Topics = append(response.Topics, responseTopic)
+		}
+		return response, nil
+	}
+	return nil, errors.New("fakeTransport: unexpected request")
+}
+
+func (f *fakeTransport) read(topic string, partition int, records protocol.RecordReader) error {
+	for {
+		record, err := records.ReadRecord()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		key, _ := protocol.ReadAll(record.Key)
+		value, _ := protocol.ReadAll(record.Value)
+		headers := make([]kafka.Header, len(record.Headers))
+		for i, h := range record.Headers {
+			headers[i] = kafka.Header{Key: h.Key, Value: append([]byte(nil), h.Value...)}
+		}
+		f.messages = append(f.messages, kafka.Message{Topic: topic, Partition: partition, Key: key, Value: value, Headers: headers})
+	}
+}
+
+func (f *fakeTransport) sent() []kafka.Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]kafka.Message(nil), f.messages...)
+}
+
+// Новое соединение с брокером: первый запрос ждет установления соединения (TCP, TLS, SASL)
+type connTransport struct {
+	kafka.RoundTripper
+	handshake time.Duration
+	once      sync.Once
+}
+
+func (c *connTransport) RoundTrip(ctx context.Context, addr net.Addr, request kafka.Request) (protocol.Message, error) {
+	c.once.Do(func() { time.Sleep(c.handshake) })
+	return c.RoundTripper.RoundTrip(ctx, addr, request)
+}
+
+func newTestBroker(transport kafka.RoundTripper, config WriterConfig) *kafkaBroker {
+	if config.BatchSize < 1 {
+		config.BatchSize = 1
+	}
+	if config.BatchTimeout <= 0 {
+		config.BatchTimeout = time.Millisecond
+	}
+	return &kafkaBroker{
+		producers:    make(map[string]*producer),
+		writerConfig: config,
+		brokers:      []string{"localhost:9092"},
+		transport:    transport,
+		dlqTopic:     "dlq",
+		lg:           zap.NewNop(),
+	}
+}
@@ -0,0 +1,30 @@
+package model
+
+import "context"
+
+type contextKey int
+
+const (
+	correlationIDKey contextKey = iota
+	sourceKey
+)
+
+// Сквозной идентификатор запроса или ответа: заголовок сообщения брокера, логи, вебхуки источнику
+func WithCorrelationID(ctx context.Context, correlationID string) context.Context {
+	return context.WithValue(ctx, correlationIDKey, correlationID)
+}
+
+func CorrelationID(ctx context.Context) string {
+	correlationID, _ := ctx.Value(correlationIDKey).(string)
+	return correlationID
+}
+
+// Источник запроса, передается в заголовке сообщения брокера
+func WithSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, sourceKey, source)
+}
+
+func Source(ctx context.Context) string {
+	source, _ := ctx.Value(sourceKey).(string)
+	return source
+}
@@ -0,0 +1,25 @@
+package model
+
+// Событие нарушения срока SLA, отправляется в источник и в топик эскалаций
+type Escalation struct {
+	TicketID                    string `json:"ticket_id,omitempty"`
+	Source                      string `json:"source,omitempty"`
+	CustomerInternalID          string `json:"customer_internal_id,omitempty"`
+	IDChannelOperatorForBilling string `json:"tt_for_billing,omitempty"`
+	IDChannelOperator           string `json:"id_channel_operator,omitempty"`
+	TTClassification            string `json:"problem_type,omitempty"`
+	OperatorTTId                string `json:"tt_number,omitempty"`
+	Status                      string `json:"status,omitempty"`
+	Policy                      string `json:"policy,omitempty"`
+	Deadline                    string `json:"deadline,omitempty"`
+	DueTimeTS                   int64  `json:"due_time_ts,omitempty"`
+	EventTimeTS                 int64  `json:"event_time_timestamp,omitempty"`
+}
+
+// Ключ упорядочивания, как у запросов по тому же клиенту (Ticket.Key)
+func (e *Escalation) Key() string {
+	if e.CustomerInternalID != "" {
+		return e.CustomerInternalID
+	}
+	return e.OperatorTTId
+}
@@ -0,0 +1,48 @@
+package model
+
+import "time"
+
+type EventDirection string
+
+const (
+	ToSystem   EventDirection = "to_system"   //запрос источника, отправленный в систему
+	FromSystem EventDirection = "from_system" //ответ системы
+	Controller EventDirection = "controller"  //действие контроллера: перенаправление, отказ
+)
+
+// Запись журнала событий по запросу
+type TicketEvent struct {
+	TicketID                    string         `json:"ticket_id"`
+	Direction                   EventDirection `json:"direction"`
+	MessageType                 RequestType    `json:"message_type,omitempty"`
+	IDChannelOperatorForBilling string         `json:"tt_for_billing,omitempty"`
+	OperatorTTId                string         `json:"tt_number,omitempty"`
+	Status                      string         `json:"status,omitempty"`
+	Comment                     string         `json:"comment,omitempty"`
+	User                        string         `json:"user,omitempty"`
+	EventTimeTS                 int64          `json:"event_time_timestamp,omitempty"`
+	RecordedTS                  int64          `json:"recorded_timestamp"`
+}
+
+func NewTicketEvent(ticketID string, direction EventDirection, ticket *Ticket) *TicketEvent {
+	return &TicketEvent{
+		TicketID:                    ticketID,
+		Direction:                   direction,
+		MessageType:                 ticket.MessageType,
+		IDChannelOperatorForBilling: ticket.IDChannelOperatorForBilling,
+		OperatorTTId:                ticket.OperatorTTId,
+		Status:                      ticket.TTStatus,
+		Comment:                     ticket.Comment,
+		User:                        ticket.User,
+		EventTimeTS:                 ticket.EventTimestamp,
+		RecordedTS:                  time.Now().Unix(),
+	}
+}
+
+// События совпадают без учета TicketID и времени записи
+func (e *TicketEvent) Same(other *TicketEvent) bool {
+	a, b := *e, *other
+	a.TicketID, b.TicketID = "", ""
+	a.RecordedTS, b.RecordedTS = 0, 0
+	return a == b
+}
@@ -0,0 +1,15 @@
+package model
+
+import "context"
+
+// Ответ системы из брокера. Done вызывается после завершения обработки ответа,
+// до этого смещение сообщения в брокере не фиксируется. Ответ, который не удалось обработать,
+// сохраняется через DeadLetter, после успешной записи его можно завершить. DeadLetter повторяет запись,
+// пока она не удастся или не будет остановлен ctx или consumer
+type Message struct {
+	ID            string //заголовок message_id, если система его передает
+	CorrelationID string //заголовок correlation_id
+	Ticket        *Ticket
+	Done          func()
+	DeadLetter    func(ctx context.Context, cause error) error
+}
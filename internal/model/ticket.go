@@ -9,14 +9,14 @@ const (
 	Wait   RequestType = "wait"
 	Reopen RequestType = "reopen"
 	Close  RequestType = "close"
-	Done   RequestType = "done"
+	Done   RequestType = "done" //ответ системы о закрытии запроса, при разборе приводится к Close
 )
 
 type TTStatus string
 
 const (
 	Creating TTStatus = "creating" //не заведен ни в одну систему, ожидается ответ
-	Error    TTStatus = "error"    //первая система отказала в заведении, запрос направлен во вторую
+	Error    TTStatus = "error"    //система отказала в заведении, запрос направлен в следующую
 	Working  TTStatus = "working"
 	Waiting  TTStatus = "waiting"
 	Closed   TTStatus = "closed"
@@ -41,7 +41,17 @@ type Ticket struct {
 	User                        string      `json:"tt_user,omitempty"`
 }
 
+// Ключ упорядочивания: сообщения по одному клиенту обрабатываются по порядку.
+// Без клиента - номер запроса в системе
+func (t *Ticket) Key() string {
+	if t.CustomerInternalId != "" {
+		return t.CustomerInternalId
+	}
+	return t.OperatorTTId
+}
+
 type TicketDTO struct {
+	TicketID                    string      `json:"ticket_id,omitempty"`
 	Source                      string      `json:"source,omitempty"`
 	MessageType                 RequestType `json:"message_type,omitempty"`
 	CustomerInternalID          string      `json:"customer_internal_id,omitempty"`
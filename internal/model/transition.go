@@ -0,0 +1,75 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrIllegalTransition = errors.New("illegal status transition")
+var ErrReopenExpired = errors.New("cannot reopen: ticket was closed and has expired from cache")
+
+type TransitionError struct {
+	From      TTStatus
+	Direction EventDirection
+	Request   RequestType
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("%s: %s %s from status %q", ErrIllegalTransition, e.Direction, e.Request, e.From)
+}
+
+func (e *TransitionError) Unwrap() error {
+	return ErrIllegalTransition
+}
+
+type transitionKey struct {
+	direction EventDirection
+	request   RequestType
+	from      TTStatus
+}
+
+// Допустимые переходы статусов запроса. Запросы источника статус не меняют (кроме создания),
+// новый статус устанавливается по ответу системы
+var transitions = map[transitionKey]TTStatus{
+	//Запросы источника в систему
+	{ToSystem, Create, ""}:      Creating,
+	{ToSystem, Status, Working}: Working,
+	{ToSystem, Status, Waiting}: Waiting,
+	{ToSystem, Status, Closed}:  Closed,
+	{ToSystem, Note, Working}:   Working,
+	{ToSystem, Note, Waiting}:   Waiting,
+	{ToSystem, Wait, Working}:   Working,
+	{ToSystem, Wait, Waiting}:   Waiting,
+	{ToSystem, Reopen, Closed}:  Closed,
+	{ToSystem, Close, Working}:  Working,
+	{ToSystem, Close, Waiting}:  Waiting,
+
+	//Ответы систем
+	{FromSystem, Create, Creating}: Working,
+	{FromSystem, Create, Error}:    Working,
+	{FromSystem, Status, Working}:  Working,
+	{FromSystem, Status, Waiting}:  Waiting,
+	{FromSystem, Status, Closed}:   Closed,
+	{FromSystem, Note, Working}:    Working,
+	{FromSystem, Note, Waiting}:    Waiting,
+	{FromSystem, Wait, Working}:    Waiting,
+	{FromSystem, Wait, Waiting}:    Waiting,
+	{FromSystem, Reopen, Closed}:   Working,
+	{FromSystem, Close, Working}:   Closed,
+	{FromSystem, Close, Waiting}:   Closed,
+
+	//Действия контроллера: create - перенаправление в следующую систему, close - отказ всех систем
+	{Controller, Create, Creating}: Error,
+	{Controller, Create, Error}:    Error,
+	{Controller, Close, Creating}:  Closed,
+	{Controller, Close, Error}:     Closed,
+}
+
+// Новый статус запроса или *TransitionError, если переход недопустим
+func Transition(from TTStatus, direction EventDirection, request RequestType) (TTStatus, error) {
+	to, ok := transitions[transitionKey{direction: direction, request: request, from: from}]
+	if !ok {
+		return from, &TransitionError{From: from, Direction: direction, Request: request}
+	}
+	return to, nil
+}
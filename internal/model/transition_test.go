@@ -0,0 +1,68 @@
+package model
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTransition(t *testing.T) {
+	tests := []struct {
+		name      string
+		from      TTStatus
+		direction EventDirection
+		request   RequestType
+		want      TTStatus
+		err       error
+	}{
+		{name: "source creates ticket", from: "", direction: ToSystem, request: Create, want: Creating},
+		{name: "source cannot create twice", from: Creating, direction: ToSystem, request: Create, want: Creating, err: ErrIllegalTransition},
+		{name: "system accepts ticket", from: Creating, direction: FromSystem, request: Create, want: Working},
+		{name: "next system accepts rerouted ticket", from: Error, direction: FromSystem, request: Create, want: Working},
+		{name: "controller reroutes", from: Creating, direction: Controller, request: Create, want: Error},
+		{name: "controller reroutes again", from: Error, direction: Controller, request: Create, want: Error},
+		{name: "controller declines", from: Error, direction: Controller, request: Close, want: Closed},
+		{name: "controller cannot decline working ticket", from: Working, direction: Controller, request: Close, want: Working, err: ErrIllegalTransition},
+		{name: "source note keeps status", from: Waiting, direction: ToSystem, request: Note, want: Waiting},
+		{name: "source note before acceptance", from: Creating, direction: ToSystem, request: Note, want: Creating, err: ErrIllegalTransition},
+		{name: "system waits", from: Working, direction: FromSystem, request: Wait, want: Waiting},
+		{name: "source close waits for system", from: Working, direction: ToSystem, request: Close, want: Working},
+		{name: "system closes", from: Waiting, direction: FromSystem, request: Close, want: Closed},
+		{name: "closed ticket cannot be closed", from: Closed, direction: FromSystem, request: Close, want: Closed, err: ErrIllegalTransition},
+		{name: "source reopens closed ticket", from: Closed, direction: ToSystem, request: Reopen, want: Closed},
+		{name: "system reopens", from: Closed, direction: FromSystem, request: Reopen, want: Working},
+		{name: "working ticket cannot be reopened", from: Working, direction: ToSystem, request: Reopen, want: Working, err: ErrIllegalTransition},
+		{name: "status reply on closed ticket", from: Closed, direction: FromSystem, request: Status, want: Closed},
+		{name: "unknown request", from: Working, direction: FromSystem, request: "unknown", want: Working, err: ErrIllegalTransition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Transition(tt.from, tt.direction, tt.request)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Fatalf("status = %q, want %q", got, tt.want)
+			}
+			if tt.err == nil {
+				return
+			}
+			var transitionError *TransitionError
+			if !errors.As(err, &transitionError) || transitionError.From != tt.from || transitionError.Request != tt.request {
+				t.Fatalf("err = %#v, want *TransitionError", err)
+			}
+		})
+	}
+}
+
+// Ни один переход не выводит запрос из известных статусов
+func TestTransitionTargets(t *testing.T) {
+	statuses := map[TTStatus]bool{Creating: true, Error: true, Working: true, Waiting: true, Closed: true}
+	for key, to := range transitions {
+		if !statuses[to] {
+			t.Errorf("%s %s from %q leads to unknown status %q", key.direction, key.request, key.from, to)
+		}
+		if key.from != "" && !statuses[key.from] {
+			t.Errorf("%s %s from unknown status %q", key.direction, key.request, key.from)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Очередь в памяти процесса, события теряются при перезапуске
+type memoryStore struct {
+	mu      sync.Mutex
+	pending map[string]Event
+	order   map[string]int64 //порядок сохранения событий запросов
+	seq     int64
+	dead    map[string]Event
+	lg      *zap.Logger
+}
+
+func NewMemoryStore(lg *zap.Logger) Store {
+	return &memoryStore{
+		pending: make(map[string]Event),
+		order:   make(map[string]int64),
+		dead:    make(map[string]Event),
+		lg:      lg,
+	}
+}
+
+func (m *memoryStore) Save(ctx context.Context, event *Event) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("outbox.Save: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.save(*event)
+	return nil
+}
+
+func (m *memoryStore) save(event Event) {
+	if _, ok := m.order[event.ID]; !ok && event.TicketID != "" {
+		m.seq++
+		m.order[event.ID] = m.seq
+	}
+	m.pending[event.ID] = event
+}
+
+func (m *memoryStore) remove(id string) {
+	delete(m.pending, id)
+	delete(m.order, id)
+}
+
+// Событие запроса ждет доставки более раннего события этого запроса
+func (m *memoryStore) held(event Event) bool {
+	if event.TicketID == "" {
+		return false
+	}
+	for id, other := range m.pending {
+		if id != event.ID && other.TicketID == event.TicketID && m.order[id] < m.order[event.ID] {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *memoryStore) Claim(ctx context.Context, now, lease int64, limit int) ([]*Event, error) {
+	events := make([]*Event, 0)
+	if err := ctx.Err(); err != nil {
+		return events, fmt.Errorf("outbox.Claim: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	due := make([]Event, 0)
+	for _, event := range m.pending {
+		if event.NextAttemptTS <= now && !m.held(event) {
+			due = append(due, event)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextAttemptTS < due[j].NextAttemptTS
+	})
+	for i := range due {
+		if len(events) == limit {
+			break
+		}
+		event := due[i]
+		//В хранилище откладываем до окончания аренды, вызывающему отдаем исходное событие
+		leased := event
+		leased.NextAttemptTS = lease
+		m.pending[event.ID] = leased
+		events = append(events, &event)
+	}
+	return events, nil
+}
+
+func (m *memoryStore) Delete(ctx context.Context, event *Event) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("outbox.Delete: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(event.ID)
+	return nil
+}
+
+func (m *memoryStore) DeadLetter(ctx context.Context, event *Event) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("outbox.DeadLetter: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(event.ID)
+	m.dead[event.ID] = *event
+	return nil
+}
+
+func (m *memoryStore) GetDeadLetters(ctx context.Context) ([]*Event, error) {
+	events := make([]*Event, 0)
+	if err := ctx.Err(); err != nil {
+		return events, fmt.Errorf("outbox.GetDeadLetters: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, event := range m.dead {
+		event := event
+		events = append(events, &event)
+	}
+	sortByCreated(events)
+	return events, nil
+}
+
+func (m *memoryStore) Redrive(ctx context.Context, id string, now int64) (*Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("outbox.Redrive: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	event, ok := m.dead[id]
+	if !ok {
+		return nil, fmt.Errorf("outbox.Redrive: %w", ErrNotFound)
+	}
+	delete(m.dead, id)
+	event.Attempts = 0
+	event.LastError = ""
+	event.NextAttemptTS = now
+	m.save(event)
+	return &event, nil
+}
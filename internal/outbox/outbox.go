@@ -0,0 +1,231 @@
+package outbox
+
+import (
+	"TController/internal/cache"
+	"TController/internal/model"
+	"TController/internal/sources"
+	"TController/pkg/webhook"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type Config struct {
+	Interval    time.Duration //период проверки очереди
+	Timeout     time.Duration //таймаут одной попытки доставки
+	MaxAttempts int           //после последней неудачной попытки событие уходит в dead-letter
+	Backoff     time.Duration //задержка перед второй попыткой, далее удваивается
+	MaxBackoff  time.Duration
+	BatchSize   int
+}
+
+type Outbox interface {
+	//Сохраняет событие запроса ticketID для source, доставка выполняется асинхронно в Run.
+	//Пока событие запроса ожидает повторной попытки, следующие события этого запроса не отправляются
+	Enqueue(ctx context.Context, ticketID, source, uri string, body []byte) error
+	Run()
+	GetDeadLetters(ctx context.Context) ([]*Event, error)
+	Redrive(ctx context.Context, id string) (*Event, error)
+}
+
+type outbox struct {
+	ctx     context.Context
+	store   Store
+	client  *http.Client
+	sources sources.Registry
+	config  Config
+	now     func() time.Time
+	lg      *zap.Logger
+}
+
+func NewOutbox(ctx context.Context, store Store, sources sources.Registry, config Config, lg *zap.Logger) Outbox {
+	return &outbox{
+		ctx:     ctx,
+		store:   store,
+		client:  &http.Client{},
+		sources: sources,
+		config:  config,
+		now:     time.Now,
+		lg:      lg,
+	}
+}
+
+func (o *outbox) Enqueue(ctx context.Context, ticketID, source, uri string, body []byte) error {
+	now := o.now().Unix()
+	event := Event{
+		ID:            cache.NewTicketID(),
+		TicketID:      ticketID,
+		Source:        source,
+		URI:           uri,
+		Body:          body,
+		Created:       now,
+		NextAttemptTS: now,
+		CorrelationID: model.CorrelationID(ctx),
+	}
+	err := o.store.Save(ctx, &event)
+	if err != nil {
+		return fmt.Errorf("outbox.Enqueue: %w", err)
+	}
+	return nil
+}
+
+// Доставка событий раз в interval до отмены контекста
+func (o *outbox) Run() {
+	ticker := time.NewTicker(o.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-o.ctx.Done():
+			return
+		case <-ticker.C:
+			err := o.deliverDue()
+			if err != nil {
+				o.lg.Error("outbox.Run", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (o *outbox) deliverDue() error {
+	now := o.now()
+	//Аренда покрывает все попытки пачки, иначе событие заберет другой экземпляр
+	lease := now.Add(o.config.Timeout*time.Duration(o.config.BatchSize) + o.config.Interval)
+	events, err := o.store.Claim(o.ctx, now.Unix(), lease.Unix(), o.config.BatchSize)
+	if err != nil {
+		return fmt.Errorf("outbox.deliverDue: %w", err)
+	}
+	//Попытки идут по очереди: если у источников пачки таймаут больше общего, аренда продлевается
+	var timeout time.Duration
+	for _, event := range events {
+		if t := o.timeout(event.Source); t > timeout {
+			timeout = t
+		}
+	}
+	extended := now.Add(timeout*time.Duration(len(events)) + o.config.Interval)
+	if extended.After(lease) {
+		for _, event := range events {
+			leased := *event
+			leased.NextAttemptTS = extended.Unix()
+			err = o.store.Save(o.ctx, &leased)
+			if err != nil {
+				return fmt.Errorf("outbox.deliverDue: %w", err)
+			}
+		}
+	}
+	for _, event := range events {
+		err = o.deliver(event)
+		if err == nil {
+			err = o.store.Delete(o.ctx, event)
+			if err != nil {
+				o.lg.Error("outbox.deliverDue", zap.String("id", event.ID), zap.Error(err))
+			}
+			continue
+		}
+		event.Attempts++
+		event.LastError = err.Error()
+		if event.Attempts >= o.config.MaxAttempts {
+			o.lg.Error("outbox.deliverDue: webhook event dead-lettered",
+				zap.String("id", event.ID),
+				zap.String("source", event.Source),
+				zap.String("correlation_id", event.CorrelationID),
+				zap.Int("attempts", event.Attempts),
+				zap.Error(err))
+			err = o.store.DeadLetter(o.ctx, event)
+			if err != nil {
+				o.lg.Error("outbox.deliverDue", zap.String("id", event.ID), zap.Error(err))
+			}
+			continue
+		}
+		event.NextAttemptTS = o.now().Add(o.backoff(event.Attempts)).Unix()
+		o.lg.Warn("outbox.deliverDue: webhook delivery failed",
+			zap.String("id", event.ID),
+			zap.String("source", event.Source),
+			zap.String("correlation_id", event.CorrelationID),
+			zap.Int("attempts", event.Attempts),
+			zap.Error(err))
+		err = o.store.Save(o.ctx, event)
+		if err != nil {
+			o.lg.Error("outbox.deliverDue", zap.String("id", event.ID), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// Задержка после attempts неудачных попыток: Backoff, 2*Backoff, 4*Backoff... не больше MaxBackoff
+func (o *outbox) backoff(attempts int) time.Duration {
+	delay := o.config.Backoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= o.config.MaxBackoff {
+			return o.config.MaxBackoff
+		}
+	}
+	return delay
+}
+
+// Таймаут доставки источника, если не задан - общий
+func (o *outbox) timeout(name string) time.Duration {
+	source, ok := o.sources.Get(name)
+	if ok && source.Timeout > 0 {
+		return time.Duration(source.Timeout)
+	}
+	return o.config.Timeout
+}
+
+// Таймаут и авторизация - по настройкам источника на момент попытки
+func (o *outbox) deliver(event *Event) error {
+	source, ok := o.sources.Get(event.Source)
+	ctx, cancel := context.WithTimeout(o.ctx, o.timeout(event.Source))
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, event.URI, bytes.NewReader(event.Body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if event.CorrelationID != "" {
+		request.Header.Set(webhook.HeaderCorrelationID, event.CorrelationID)
+	}
+	if ok {
+		switch source.Auth {
+		case sources.AuthHMAC:
+			//Подпись на каждую попытку своя: новые timestamp и nonce
+			err = webhook.SignRequest(request, []byte(source.Secret), event.Body, o.now())
+			if err != nil {
+				return err
+			}
+		case sources.AuthBearer:
+			request.Header.Set("Authorization", "Bearer "+source.Secret)
+		}
+	}
+	response, err := o.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("%s", response.Status)
+	}
+	return nil
+}
+
+func (o *outbox) GetDeadLetters(ctx context.Context) ([]*Event, error) {
+	events, err := o.store.GetDeadLetters(ctx)
+	if err != nil {
+		return events, fmt.Errorf("outbox.GetDeadLetters: %w", err)
+	}
+	return events, nil
+}
+
+func (o *outbox) Redrive(ctx context.Context, id string) (*Event, error) {
+	event, err := o.store.Redrive(ctx, id, o.now().Unix())
+	if err != nil {
+		return nil, fmt.Errorf("outbox.Redrive: %w", err)
+	}
+	return event, nil
+}
@@ -0,0 +1,217 @@
+package outbox
+
+import (
+	"TController/internal/model"
+	"TController/internal/sources"
+	"TController/pkg/webhook"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Хранилище, которое запоминает сохраненные события
+type recordingStore struct {
+	Store
+	saved []Event
+}
+
+func (r *recordingStore) Save(ctx context.Context, event *Event) error {
+	r.saved = append(r.saved, *event)
+	return r.Store.Save(ctx, event)
+}
+
+func newTestOutbox(t *testing.T, list []sources.Source, config Config) (*outbox, *recordingStore, time.Time) {
+	registry, err := sources.NewRegistry(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &recordingStore{Store: NewMemoryStore(zap.NewNop())}
+	now := time.Unix(1700000000, 0)
+	o := NewOutbox(context.Background(), store, registry, config, zap.NewNop()).(*outbox)
+	o.now = func() time.Time { return now }
+	return o, store, now
+}
+
+func TestBackoff(t *testing.T) {
+	o := &outbox{config: Config{Backoff: time.Second, MaxBackoff: 10 * time.Second}}
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{10, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := o.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestDeliverDue(t *testing.T) {
+	config := Config{Interval: time.Second, Timeout: time.Second, MaxAttempts: 3, Backoff: 5 * time.Second, MaxBackoff: time.Minute, BatchSize: 10}
+	tests := []struct {
+		name     string
+		status   int
+		attempts int //попыток до этой
+		pending  bool
+		dead     bool
+		next     time.Duration
+	}{
+		{name: "delivered", status: http.StatusOK},
+		{name: "retry with backoff", status: http.StatusInternalServerError, pending: true, next: 5 * time.Second},
+		{name: "second retry doubles delay", status: http.StatusBadGateway, attempts: 1, pending: true, next: 10 * time.Second},
+		{name: "last attempt dead-lettered", status: http.StatusInternalServerError, attempts: 2, dead: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var header http.Header
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				header = r.Header
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+			list := []sources.Source{{Name: "api", Auth: sources.AuthHMAC, Secret: "secret", Enabled: true}}
+			o, store, now := newTestOutbox(t, list, config)
+			ctx := model.WithCorrelationID(context.Background(), "corr-1")
+			event := &Event{ID: "e1", Source: "api", URI: server.URL, Body: []byte(`{}`), Attempts: tt.attempts, NextAttemptTS: now.Unix(), CorrelationID: model.CorrelationID(ctx)}
+			err := store.Save(ctx, event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			store.saved = nil
+
+			err = o.deliverDue()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.Get(webhook.HeaderCorrelationID) != "corr-1" {
+				t.Errorf("correlation header = %q", header.Get(webhook.HeaderCorrelationID))
+			}
+			if header.Get(webhook.HeaderSignature) == "" {
+				t.Error("request is not signed")
+			}
+			pending, _ := store.Claim(context.Background(), now.Add(time.Hour).Unix(), now.Add(time.Hour).Unix(), 10)
+			if (len(pending) == 1) != tt.pending {
+				t.Fatalf("pending = %d, want %v", len(pending), tt.pending)
+			}
+			if tt.pending {
+				if pending[0].Attempts != tt.attempts+1 {
+					t.Errorf("attempts = %d", pending[0].Attempts)
+				}
+				if pending[0].NextAttemptTS != now.Add(tt.next).Unix() {
+					t.Errorf("next attempt in %ds, want %v", pending[0].NextAttemptTS-now.Unix(), tt.next)
+				}
+			}
+			dead, _ := store.GetDeadLetters(context.Background())
+			if (len(dead) == 1) != tt.dead {
+				t.Fatalf("dead letters = %d, want %v", len(dead), tt.dead)
+			}
+		})
+	}
+}
+
+func TestLeaseCoversSourceTimeout(t *testing.T) {
+	config := Config{Interval: time.Second, Timeout: time.Second, MaxAttempts: 3, Backoff: time.Second, MaxBackoff: time.Minute, BatchSize: 2}
+	tests := []struct {
+		name   string
+		source time.Duration
+		lease  time.Duration //0 - аренда не продлевается
+	}{
+		{name: "default timeout", lease: 0},
+		{name: "shorter source timeout", source: 500 * time.Millisecond, lease: 0},
+		{name: "longer source timeout", source: 30 * time.Second, lease: 2*30*time.Second + time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			defer server.Close()
+			list := []sources.Source{
+				{Name: "slow", Timeout: model.Duration(tt.source), Enabled: true},
+				{Name: "fast", Enabled: true},
+			}
+			o, store, now := newTestOutbox(t, list, config)
+			for _, event := range []*Event{
+				{ID: "e1", Source: "fast", URI: server.URL, NextAttemptTS: now.Unix()},
+				{ID: "e2", Source: "slow", URI: server.URL, NextAttemptTS: now.Unix()},
+			} {
+				_ = store.Save(context.Background(), event)
+			}
+			store.saved = nil
+
+			err := o.deliverDue()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.lease == 0 {
+				if len(store.saved) != 0 {
+					t.Fatalf("lease extended: %+v", store.saved)
+				}
+				return
+			}
+			if len(store.saved) != 2 {
+				t.Fatalf("extended %d events, want 2", len(store.saved))
+			}
+			for _, event := range store.saved {
+				if event.NextAttemptTS != now.Add(tt.lease).Unix() {
+					t.Errorf("%s leased for %ds, want %v", event.ID, event.NextAttemptTS-now.Unix(), tt.lease)
+				}
+			}
+		})
+	}
+}
+
+// Пока событие запроса ждет повторной попытки, следующие события этого запроса не отправляются,
+// события других запросов отправляются
+func TestDeliverTicketEventsInOrder(t *testing.T) {
+	config := Config{Interval: time.Second, Timeout: time.Second, MaxAttempts: 3, Backoff: 5 * time.Second, MaxBackoff: time.Minute, BatchSize: 10}
+	var delivered []string
+	fail := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) == "T1-1" && fail {
+			fail = false
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		delivered = append(delivered, string(body))
+	}))
+	defer server.Close()
+	o, _, now := newTestOutbox(t, []sources.Source{{Name: "api", CallbackURL: server.URL, Enabled: true}}, config)
+	for _, event := range []struct{ ticket, body string }{{"T1", "T1-1"}, {"T1", "T1-2"}, {"T2", "T2-1"}, {"T1", "T1-3"}} {
+		err := o.Enqueue(context.Background(), event.ticket, "api", server.URL, []byte(event.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	steps := []struct {
+		after time.Duration
+		want  []string
+	}{
+		{after: 0, want: []string{"T2-1"}},
+		{after: time.Second, want: []string{"T2-1"}},
+		{after: 5 * time.Second, want: []string{"T2-1", "T1-1"}},
+		{after: 6 * time.Second, want: []string{"T2-1", "T1-1", "T1-2"}},
+		{after: 7 * time.Second, want: []string{"T2-1", "T1-1", "T1-2", "T1-3"}},
+	}
+	for _, step := range steps {
+		o.now = func() time.Time { return now.Add(step.after) }
+		err := o.deliverDue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(delivered, step.want) {
+			t.Fatalf("after %v delivered %v, want %v", step.after, delivered, step.want)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package outbox
+
+import (
+	"TController/internal/cache"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gomodule/redigo/redis"
+	"go.uber.org/zap"
+)
+
+// Очередь - sorted set идентификаторов по времени следующей попытки, события хранятся без TTL.
+// Порядок событий запроса - sorted set Outbox:Ticket:<TicketID> по номеру сохранения,
+// запрос события - в hash Outbox:Tickets
+const (
+	pendingKey      = "Outbox:Pending"
+	deadKey         = "Outbox:Dead"
+	ticketsKey      = "Outbox:Tickets"
+	seqKey          = "Outbox:Seq"
+	ticketKeyPrefix = "Outbox:Ticket:"
+)
+
+// KEYS[1] - событие, KEYS[2] - очередь, KEYS[3] - Outbox:Tickets, KEYS[4] - порядок событий запроса,
+// KEYS[5] - счетчик, KEYS[6] - dead-letter; ARGV[1] - событие, ARGV[2] - время попытки, ARGV[3] - ID, ARGV[4] - TicketID.
+// Номер в порядке запроса присваивается при первом сохранении, событие из dead-letter встает в конец
+var saveScript = redis.NewScript(6, `
+redis.call("SET", KEYS[1], ARGV[1])
+redis.call("ZADD", KEYS[2], ARGV[2], ARGV[3])
+redis.call("HDEL", KEYS[6], ARGV[3])
+if ARGV[4] ~= "" and not redis.call("ZSCORE", KEYS[4], ARGV[3]) then
+	redis.call("ZADD", KEYS[4], redis.call("INCR", KEYS[5]), ARGV[3])
+	redis.call("HSET", KEYS[3], ARGV[3], ARGV[4])
+end
+return 1
+`)
+
+// KEYS[1] - очередь, KEYS[2] - Outbox:Tickets; ARGV[1] - текущее время, ARGV[2] - время окончания аренды,
+// ARGV[3] - лимит, ARGV[4] - префикс порядка событий запроса. Событие забирается, только если оно первое у запроса
+var claimScript = redis.NewScript(2, `
+local claimed = {}
+local ids = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
+for _, id in ipairs(ids) do
+	if #claimed >= tonumber(ARGV[3]) then
+		break
+	end
+	local ticket = redis.call("HGET", KEYS[2], id)
+	local first = ticket and redis.call("ZRANGE", ARGV[4] .. ticket, 0, 0)[1]
+	if not first or first == id then
+		redis.call("ZADD", KEYS[1], ARGV[2], id)
+		table.insert(claimed, id)
+	end
+end
+return claimed
+`)
+
+type redisStore struct {
+	pool *redis.Pool
+	lg   *zap.Logger
+}
+
+func NewRedisStore(pool *redis.Pool, lg *zap.Logger) Store {
+	return &redisStore{pool: pool, lg: lg}
+}
+
+func eventKey(id string) string {
+	return fmt.Sprintf("Outbox:Event:%s", id)
+}
+
+func (s *redisStore) Save(ctx context.Context, event *Event) error {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return fmt.Errorf("outbox.Save: %w", err)
+	}
+	defer conn.Close()
+	err = s.save(ctx, conn, event)
+	if err != nil {
+		return fmt.Errorf("outbox.Save: %w", err)
+	}
+	return nil
+}
+
+func (s *redisStore) save(ctx context.Context, conn redis.Conn, event *Event) error {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = saveScript.DoContext(ctx, conn,
+		eventKey(event.ID), pendingKey, ticketsKey, ticketKeyPrefix+event.TicketID, seqKey, deadKey,
+		value, event.NextAttemptTS, event.ID, event.TicketID)
+	return err
+}
+
+// Удаляет событие из очереди и из порядка событий запроса
+func remove(tx *cache.Transaction, event *Event) {
+	tx.Send("DEL", eventKey(event.ID))
+	tx.Send("ZREM", pendingKey, event.ID)
+	if event.TicketID != "" {
+		tx.Send("ZREM", ticketKeyPrefix+event.TicketID, event.ID)
+		tx.Send("HDEL", ticketsKey, event.ID)
+	}
+}
+
+func (s *redisStore) Claim(ctx context.Context, now, lease int64, limit int) ([]*Event, error) {
+	events := make([]*Event, 0)
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return events, fmt.Errorf("outbox.Claim: %w", err)
+	}
+	defer conn.Close()
+	ids, err := redis.Strings(claimScript.DoContext(ctx, conn, pendingKey, ticketsKey, now, lease, limit, ticketKeyPrefix))
+	if err != nil {
+		return events, fmt.Errorf("outbox.Claim: %w", err)
+	}
+	for _, id := range ids {
+		value, err := redis.Bytes(redis.DoWithTimeout(conn, cache.TIMEOUT, "GET", eventKey(id)))
+		if errors.Is(err, redis.ErrNil) {
+			//Событие удалено, идентификатор остался в очереди
+			_, err = redis.DoWithTimeout(conn, cache.TIMEOUT, "ZREM", pendingKey, id)
+			if err != nil {
+				return events, fmt.Errorf("outbox.Claim: %w", err)
+			}
+			continue
+		}
+		if err != nil {
+			return events, fmt.Errorf("outbox.Claim: %w", err)
+		}
+		var event Event
+		err = json.Unmarshal(value, &event)
+		if err != nil {
+			return events, fmt.Errorf("outbox.Claim: %w", err)
+		}
+		events = append(events, &event)
+	}
+	return events, nil
+}
+
+func (s *redisStore) Delete(ctx context.Context, event *Event) error {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return fmt.Errorf("outbox.Delete: %w", err)
+	}
+	defer conn.Close()
+	tx := cache.Multi(conn)
+	remove(tx, event)
+	err = tx.Exec()
+	if err != nil {
+		return fmt.Errorf("outbox.Delete: %w", err)
+	}
+	return nil
+}
+
+func (s *redisStore) DeadLetter(ctx context.Context, event *Event) error {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("outbox.DeadLetter: %w", err)
+	}
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return fmt.Errorf("outbox.DeadLetter: %w", err)
+	}
+	defer conn.Close()
+	tx := cache.Multi(conn)
+	tx.Send("HSET", deadKey, event.ID, value)
+	remove(tx, event)
+	err = tx.Exec()
+	if err != nil {
+		return fmt.Errorf("outbox.DeadLetter: %w", err)
+	}
+	return nil
+}
+
+func (s *redisStore) GetDeadLetters(ctx context.Context) ([]*Event, error) {
+	events := make([]*Event, 0)
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return events, fmt.Errorf("outbox.GetDeadLetters: %w", err)
+	}
+	defer conn.Close()
+	values, err := redis.ByteSlices(redis.DoWithTimeout(conn, cache.TIMEOUT, "HVALS", deadKey))
+	if err != nil {
+		return events, fmt.Errorf("outbox.GetDeadLetters: %w", err)
+	}
+	for _, value := range values {
+		var event Event
+		err = json.Unmarshal(value, &event)
+		if err != nil {
+			return events, fmt.Errorf("outbox.GetDeadLetters: %w", err)
+		}
+		events = append(events, &event)
+	}
+	sortByCreated(events)
+	return events, nil
+}
+
+func (s *redisStore) Redrive(ctx context.Context, id string, now int64) (*Event, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("outbox.Redrive: %w", err)
+	}
+	defer conn.Close()
+	value, err := redis.Bytes(redis.DoWithTimeout(conn, cache.TIMEOUT, "HGET", deadKey, id))
+	if errors.Is(err, redis.ErrNil) {
+		return nil, fmt.Errorf("outbox.Redrive: %w", ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("outbox.Redrive: %w", err)
+	}
+	var event Event
+	err = json.Unmarshal(value, &event)
+	if err != nil {
+		return nil, fmt.Errorf("outbox.Redrive: %w", err)
+	}
+	event.Attempts = 0
+	event.LastError = ""
+	event.NextAttemptTS = now
+	//Событие возвращается в очередь и удаляется из dead-letter одной операцией
+	err = s.save(ctx, conn, &event)
+	if err != nil {
+		return nil, fmt.Errorf("outbox.Redrive: %w", err)
+	}
+	return &event, nil
+}
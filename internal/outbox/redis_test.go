@@ -0,0 +1,103 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"go.uber.org/zap"
+)
+
+// Соединение, которое записывает отправленные команды и отвечает из replies по имени команды
+type fakeConn struct {
+	replies map[string]interface{}
+	sendErr error
+	sent    []string
+}
+
+func (c *fakeConn) Close() error { return nil }
+func (c *fakeConn) Err() error   { return nil }
+func (c *fakeConn) Flush() error { return nil }
+
+func (c *fakeConn) Do(command string, args ...interface{}) (interface{}, error) {
+	if command == "" {
+		return nil, nil
+	}
+	c.sent = append(c.sent, command)
+	reply := c.replies[command]
+	if err, ok := reply.(error); ok {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (c *fakeConn) DoWithTimeout(_ time.Duration, command string, args ...interface{}) (interface{}, error) {
+	return c.Do(command, args...)
+}
+
+func (c *fakeConn) Send(command string, args ...interface{}) error {
+	if c.sendErr != nil {
+		return c.sendErr
+	}
+	c.sent = append(c.sent, command)
+	return nil
+}
+
+func (c *fakeConn) Receive() (interface{}, error) { return nil, nil }
+
+func (c *fakeConn) ReceiveWithTimeout(time.Duration) (interface{}, error) { return nil, nil }
+
+func newFakeStore(conn *fakeConn) Store {
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { return conn, nil }}
+	return NewRedisStore(pool, zap.NewNop())
+}
+
+// Ошибка отправки команды или команды внутри EXEC возвращается вызывающему
+func TestRedisStoreTransactionErrors(t *testing.T) {
+	event := &Event{ID: "e1", TicketID: "T1", Source: "api"}
+	tests := []struct {
+		name   string
+		conn   *fakeConn
+		err    bool
+		noExec bool
+	}{
+		{name: "committed", conn: &fakeConn{replies: map[string]interface{}{"EXEC": []interface{}{int64(1), int64(1), int64(1), int64(1), int64(1)}}}},
+		{name: "send failed", conn: &fakeConn{sendErr: errors.New("connection reset")}, err: true, noExec: true},
+		{name: "command failed in EXEC", conn: &fakeConn{replies: map[string]interface{}{"EXEC": []interface{}{int64(1), redis.Error("WRONGTYPE"), int64(1)}}}, err: true},
+		{name: "EXEC failed", conn: &fakeConn{replies: map[string]interface{}{"EXEC": errors.New("i/o timeout")}}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore(tt.conn)
+			for _, call := range []func() error{
+				func() error { return store.Delete(context.Background(), event) },
+				func() error { return store.DeadLetter(context.Background(), event) },
+			} {
+				tt.conn.sent = nil
+				err := call()
+				if (err != nil) != tt.err {
+					t.Fatalf("err = %v, want error: %v", err, tt.err)
+				}
+				sent := strings.Join(tt.conn.sent, " ")
+				if strings.Contains(sent, "EXEC") == tt.noExec {
+					t.Fatalf("sent %s", sent)
+				}
+			}
+		})
+	}
+}
+
+func TestRedisStoreClaimRemoveError(t *testing.T) {
+	conn := &fakeConn{replies: map[string]interface{}{
+		"EVALSHA": []interface{}{[]byte("e1")},
+		"GET":     redis.ErrNil,
+		"ZREM":    errors.New("i/o timeout"),
+	}}
+	_, err := newFakeStore(conn).Claim(context.Background(), 1, 2, 10)
+	if err == nil {
+		t.Fatal("ZREM error is not returned")
+	}
+}
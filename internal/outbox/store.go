@@ -0,0 +1,59 @@
+package outbox
+
+import (
+	"TController/internal/cache"
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+var ErrNotFound = errors.New("webhook event not found")
+
+// Событие для отправки в источник. Сохраняется до первой попытки доставки.
+// События одного запроса доставляются в порядке сохранения
+type Event struct {
+	ID            string `json:"id"`
+	TicketID      string `json:"ticket_id,omitempty"`
+	Source        string `json:"source"`
+	URI           string `json:"uri"`
+	Body          []byte `json:"body"`
+	Attempts      int    `json:"attempts"`
+	LastError     string `json:"last_error,omitempty"`
+	Created       int64  `json:"created"`
+	NextAttemptTS int64  `json:"next_attempt_ts"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+type Store interface {
+	//Сохраняет событие в очередь, попытка доставки - не раньше NextAttemptTS
+	Save(ctx context.Context, event *Event) error
+	//Забирает до limit событий, срок которых наступил, и откладывает их до lease,
+	//чтобы их не забрал другой экземпляр контроллера. Недоставленные после lease вернутся в очередь.
+	//Событие запроса не забирается, пока в очереди есть более раннее событие этого запроса
+	Claim(ctx context.Context, now, lease int64, limit int) ([]*Event, error)
+	Delete(ctx context.Context, event *Event) error
+	DeadLetter(ctx context.Context, event *Event) error
+	GetDeadLetters(ctx context.Context) ([]*Event, error)
+	//Возвращает событие из dead-letter в очередь со сброшенным счетчиком попыток
+	Redrive(ctx context.Context, id string, now int64) (*Event, error)
+}
+
+// memory:// - очередь в памяти процесса, иначе DSN Redis
+func NewStore(dsn string, lg *zap.Logger) Store {
+	if strings.HasPrefix(dsn, "memory://") {
+		return NewMemoryStore(lg)
+	}
+	return NewRedisStore(cache.InitCache(dsn), lg)
+}
+
+func sortByCreated(events []*Event) {
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Created != events[j].Created {
+			return events[i].Created < events[j].Created
+		}
+		return events[i].ID < events[j].ID
+	})
+}
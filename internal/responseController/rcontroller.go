@@ -3,132 +3,449 @@ package responseController
 import (
 	"TController/internal/cache"
 	"TController/internal/model"
+	"TController/internal/outbox"
+	"TController/internal/routing"
+	"TController/internal/sources"
+	"TController/internal/statuses"
 	"TController/internal/ticketer"
-	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"net/http"
-	"regexp"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
 )
 
+var errDuplicate = errors.New("reply is already processed")
+var errNoTicket = errors.New("no cache record for reply")
+var errMessageType = errors.New("wrong message type")
+
+// Повторы обработки ответа: число попыток и задержка перед второй, далее удваивается
+var (
+	handleAttempts = 5
+	handleBackoff  = time.Second
+)
+
 type receiver struct {
-	out      chan *model.Ticket
+	ctx      context.Context
+	out      chan *model.Message
 	cache    cache.Cache
 	ticketer ticketer.Ticket
-	sources  map[string]string
+	outbox   outbox.Outbox
+	sources  sources.Registry
+	statuses statuses.Translator
+	routes   routing.Router
+	dedupTTL int64
+	dropped  int64 //отброшенные повторы ответов
 	lg       *zap.Logger
 }
 
-func NewReceiver(out chan *model.Ticket,
+// ctx - время работы контроллера: после его отмены повторы обработки прекращаются,
+// ответ не фиксируется и после перезапуска будет доставлен повторно
+func NewReceiver(ctx context.Context,
+	out chan *model.Message,
 	cache cache.Cache,
 	ticketer ticketer.Ticket,
+	outbox outbox.Outbox,
+	registry sources.Registry,
+	translator statuses.Translator,
+	routes routing.Router,
+	dedupTTL int64,
 	lg *zap.Logger) Response {
-	return &receiver{out: out, cache: cache, ticketer: ticketer, sources: make(map[string]string), lg: lg}
+	return &receiver{
+		ctx:      ctx,
+		out:      out,
+		cache:    cache,
+		ticketer: ticketer,
+		outbox:   outbox,
+		sources:  registry,
+		statuses: translator,
+		routes:   routes,
+		dedupTTL: dedupTTL,
+		lg:       lg,
+	}
 }
 
+// Ответы распределяются по n обработчикам по ключу запроса: ответы по одному запросу
+// обрабатываются одним обработчиком в порядке получения, по разным - параллельно
 func (r *receiver) InitReceiversPull(n int) {
+	if n < 1 {
+		n = 1
+	}
+	streams := make([]chan *model.Message, n)
 	for id := 1; id <= n; id++ {
-		go r.ResponseReceiver(r.out, id)
-		log.Printf("receiver %d is started", id)
+		streams[id-1] = make(chan *model.Message)
+		go r.ResponseReceiver(streams[id-1], id)
+		r.lg.Info("receiver is started", zap.Int("stream", id))
 	}
+	go r.dispatch(streams)
 }
 
-func (r *receiver) AddSource(name, uri string) {
-	r.sources[name] = uri
-	return
+func (r *receiver) dispatch(streams []chan *model.Message) {
+	for message := range r.out {
+		streams[shard(message.Ticket, len(streams))] <- message
+	}
+	for _, stream := range streams {
+		close(stream)
+	}
+}
+
+// Обработчик выбирается по номеру запроса в системе: он есть во всех ответах по заведенному запросу,
+// а клиент в ответе может быть не указан. Без номера приходит только отказ в заведении,
+// такие ответы упорядочиваются по клиенту
+func shard(ticket *model.Ticket, n int) int {
+	key := ticket.OperatorTTId
+	if key == "" {
+		key = ticket.CustomerInternalId
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return int(hash.Sum32() % uint32(n))
 }
 
-func (r *receiver) ResponseReceiver(out chan *model.Ticket, id int) {
+// Смещение ответа в брокере фиксируется после обработки или после записи необработанного ответа
+// в dead-letter топик. Запись повторяется брокером до успеха, ошибка означает остановку consumer:
+// ответ не завершается и после перезапуска будет доставлен повторно.
+// Ответ помечается обработанным только после успешной обработки, повторы отбрасываются до нее
+func (r *receiver) ResponseReceiver(out chan *model.Message, id int) {
 	for message := range out {
-		log.Printf("ResponseController.ResponseReceiver: got message by stream id %d: %v", id, message)
-		ctx := context.Background()
-		switch message.MessageType {
-		case model.Create:
-			r.CreateTicket(ctx, message)
-		case model.Reopen:
-			r.ReopenTicket(ctx, message)
-		case model.Status:
-			r.StatusTicket(ctx, message)
-		case model.Note:
-			r.NoteTicket(ctx, message)
-		case model.Wait:
-			r.WaitTicket(ctx, message)
-		case model.Close:
-			r.DoneTicket(ctx, message)
-		default:
-			r.lg.Error("ResponseController.ResponseReceiver: wrong message type")
+		key := messageKey(message)
+		//Без заголовка ответ в логах и вебхуках связывается по ключу отсева повторов
+		correlationID := message.CorrelationID
+		if correlationID == "" {
+			correlationID = key
 		}
+		ctx := model.WithCorrelationID(r.ctx, correlationID)
+		r.logger(ctx).Info("ResponseController.ResponseReceiver: got message",
+			zap.Int("stream", id),
+			zap.String("message_id", message.ID),
+			zap.String("message_type", string(message.Ticket.MessageType)),
+			zap.String("tt_erth", message.Ticket.OperatorTTId))
+		seen, err := r.cache.IsSeen(ctx, key)
+		if err != nil {
+			r.logger(ctx).Error("ResponseController.ResponseReceiver", zap.Error(err))
+		}
+		if seen {
+			dropped := atomic.AddInt64(&r.dropped, 1)
+			r.logger(ctx).Info("Duplicate reply dropped", zap.String("key", key), zap.Int64("dropped_total", dropped))
+			message.Done()
+			continue
+		}
+		handled, err := r.process(ctx, message)
+		if err != nil {
+			r.logger(ctx).Error("ResponseController.ResponseReceiver: reply is not committed", zap.String("key", key), zap.Error(err))
+			continue
+		}
+		//Ответ из dead-letter топика после повторной отправки должен быть обработан
+		if handled {
+			err = r.cache.MarkSeen(ctx, key, r.dedupTTL)
+			if err != nil {
+				r.logger(ctx).Error("ResponseController.ResponseReceiver", zap.Error(err))
+			}
+		}
+		message.Done()
 	}
 }
 
-func (r *receiver) CreateTicket(ctx context.Context, ticket *model.Ticket) {
-	cacheRecord, err := r.cache.GetFromCacheByCustomerID(ctx, ticket.CustomerInternalId)
+// Ключ ответа для отсева повторов: идентификатор сообщения, если система его передает,
+// иначе хэш номера запроса в системе, времени события, типа запроса и статуса
+func messageKey(message *model.Message) string {
+	if message.ID != "" {
+		return "id:" + message.ID
+	}
+	ticket := message.Ticket
+	hash := sha256.Sum256([]byte(strings.Join([]string{
+		ticket.OperatorTTId,
+		strconv.FormatInt(ticket.EventTimestamp, 10),
+		string(ticket.MessageType),
+		ticket.TTStatus,
+	}, "\x00")))
+	return "hash:" + hex.EncodeToString(hash[:])
+}
+
+// Логгер с correlation ID обрабатываемого ответа
+func (r *receiver) logger(ctx context.Context) *zap.Logger {
+	correlationID := model.CorrelationID(ctx)
+	if correlationID == "" {
+		return r.lg
+	}
+	return r.lg.With(zap.String("correlation_id", correlationID))
+}
+
+// Ответ обрабатывается с повторами, пока ошибка может быть временной. Необработанный ответ сохраняется
+// в dead-letter топик (handled = false), ошибка возвращается, только если до этой записи остановлен
+// контроллер или consumer. Повторы идут в обработчике ответа: следующие ответы по запросу ждут их,
+// чтобы не нарушить порядок, поэтому задержка ограничена handleAttempts и прерывается остановкой
+func (r *receiver) process(ctx context.Context, message *model.Message) (handled bool, err error) {
+	delay := handleBackoff
+	err = r.handle(ctx, message.Ticket)
+	for attempt := 1; err != nil && !permanent(err) && attempt < handleAttempts; attempt++ {
+		r.logger(ctx).Warn("ResponseController.process: retrying reply", zap.Int("attempt", attempt), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return false, fmt.Errorf("%v: %w", err, ctx.Err())
+		case <-time.After(delay):
+		}
+		delay *= 2
+		err = r.handle(ctx, message.Ticket)
+	}
+	if err == nil {
+		return true, nil
+	}
+	//Ошибка из-за остановки: ответ не фиксируется и будет обработан после перезапуска
+	if ctx.Err() != nil {
+		return false, fmt.Errorf("%v: %w", err, ctx.Err())
+	}
+	if message.DeadLetter == nil {
+		return false, err
+	}
+	dlqErr := message.DeadLetter(ctx, err)
+	if dlqErr != nil {
+		return false, fmt.Errorf("%v: %w", err, dlqErr)
+	}
+	r.logger(ctx).Warn("ResponseController.process: reply moved to dead-letter topic", zap.Error(err))
+	return false, nil
+}
+
+// Ошибки, которые повтор обработки не исправит: ответ сразу уходит в dead-letter топик
+func permanent(err error) bool {
+	return errors.Is(err, errNoTicket) ||
+		errors.Is(err, errMessageType) ||
+		errors.Is(err, cache.ErrAmbiguous) ||
+		errors.Is(err, model.ErrIllegalTransition) ||
+		errors.Is(err, model.ErrReopenExpired) ||
+		errors.Is(err, statuses.ErrUnmapped)
+}
+
+func (r *receiver) handle(ctx context.Context, ticket *model.Ticket) error {
+	switch ticket.MessageType {
+	case model.Create:
+		return r.CreateTicket(ctx, ticket)
+	case model.Reopen:
+		return r.ReopenTicket(ctx, ticket)
+	case model.Status:
+		return r.StatusTicket(ctx, ticket)
+	case model.Note:
+		return r.NoteTicket(ctx, ticket)
+	case model.Wait:
+		return r.WaitTicket(ctx, ticket)
+	case model.Close:
+		return r.DoneTicket(ctx, ticket)
+	}
+	return fmt.Errorf("ResponseController.handle: %w %q", errMessageType, ticket.MessageType)
+}
+
+func (r *receiver) CreateTicket(ctx context.Context, ticket *model.Ticket) error {
+	cacheRecord, err := r.findTicket(ctx, ticket)
 	if err != nil {
-		r.lg.Error("ResponseController.CreateTicket", zap.Error(err))
-		return
-	}
-	if cacheRecord.CustomerInternalID == "" {
-		r.lg.Error("ResponseController.ResponseReceiver: no cache record")
-		return
-	}
-	if ticket.TTStatus == "error" {
-		if cacheRecord.Status == model.Error {
-			if r.sources[cacheRecord.Source] != "" {
-				r.lg.Info("Request was declined by all ticket systems") //todo добавить идентификатор запроса
-				err = r.SendEvent(ctx, ticket, cacheRecord.Source)
-				if err != nil {
-					r.lg.Error("responseController.CreateTicket", zap.Error(err))
-					return
-				}
-			}
-			return
+		return fmt.Errorf("ResponseController.CreateTicket: %w", err)
+	}
+	if cacheRecord.TicketID == "" {
+		return fmt.Errorf("ResponseController.CreateTicket: %w", errNoTicket)
+	}
+	status, err := r.canonicalStatus(ticket)
+	if err != nil {
+		return fmt.Errorf("ResponseController.CreateTicket: %w", err)
+	}
+	if status == model.Error {
+		//Повторно доставленный отказ не должен снова переводить запрос в следующую систему
+		duplicate, err := r.processed(ctx, cacheRecord.TicketID, ticket)
+		if err != nil {
+			return fmt.Errorf("ResponseController.CreateTicket: %w", err)
 		}
-		r.ReRouteTicket(ctx, cacheRecord)
-		return
+		if duplicate {
+			r.logger(ctx).Info("Reply is already processed",
+				zap.String("ticket_id", cacheRecord.TicketID),
+				zap.String("tt_request", string(ticket.MessageType)),
+				zap.Int64("tt_ts", ticket.EventTimestamp))
+			return nil
+		}
+		err = r.ReRouteTicket(ctx, cacheRecord)
+		if err != nil {
+			return fmt.Errorf("ResponseController.CreateTicket: %w", err)
+		}
+		r.recordEvent(ctx, cacheRecord.TicketID, model.FromSystem, ticket)
+		return nil
 	}
 
-	if cacheRecord.IDChannelOperatorForBilling != ticket.IDChannelOperatorForBilling {
-		r.lg.Error("cacheRecord.IDChannelOperatorForBilling != ticket.IDChannelOperatorForBilling") //todo добавить идентификатор запроса
-		return
+	err = r.applyReply(ctx, cacheRecord, ticket, &cache.CacheRecord{
+		OperatorTTId: ticket.OperatorTTId,
+		Acknowledged: cache.Timestamp(time.Now()),
+	})
+	if err != nil && !errors.Is(err, errDuplicate) {
+		return fmt.Errorf("ResponseController.CreateTicket: %w", err)
 	}
-	cacheRecord.Status = model.Working
-	//cacheRecord.IDChannelOperatorForBilling = ticket.IDChannelOperatorForBilling
-	cacheRecord.OperatorTTId = ticket.OperatorTTId
-	cacheRecord.Modified = time.Now().String()
-	err = r.cache.WriteToCache(ctx, cacheRecord)
+	return nil
+}
+
+// Ответ по заведенному запросу: поиск запроса и переход статуса
+func (r *receiver) reply(ctx context.Context, ticket *model.Ticket) error {
+	cacheRecord, err := r.findTicket(ctx, ticket)
 	if err != nil {
-		r.lg.Error("ResponseController.CreateTicket", zap.Error(err))
-		return
+		return err
+	}
+	if cacheRecord.TicketID == "" {
+		return errNoTicket
 	}
+	err = r.applyReply(ctx, cacheRecord, ticket, &cache.CacheRecord{})
+	if err != nil && !errors.Is(err, errDuplicate) {
+		return err
+	}
+	return nil
+}
 
-	if r.sources[cacheRecord.Source] != "" {
-		err = r.SendEvent(ctx, ticket, cacheRecord.Source)
+// Переход статуса по ответу системы, недопустимый для текущего статуса ответ отклоняется.
+// В update передаются дополнительные поля для записи в кэш. Событие источнику сохраняется в outbox
+// до записи статуса: при повторной обработке после сбоя источник может получить событие дважды, но не потеряет его
+func (r *receiver) applyReply(ctx context.Context, cacheRecord *cache.CacheRecord, ticket *model.Ticket, update *cache.CacheRecord) error {
+	duplicate, err := r.processed(ctx, cacheRecord.TicketID, ticket)
+	if err != nil {
+		return fmt.Errorf("applyReply: %w", err)
+	}
+	if duplicate {
+		r.logger(ctx).Info("Reply is already processed",
+			zap.String("ticket_id", cacheRecord.TicketID),
+			zap.String("tt_request", string(ticket.MessageType)),
+			zap.Int64("tt_ts", ticket.EventTimestamp))
+		return errDuplicate
+	}
+	status, err := model.Transition(cacheRecord.Status, model.FromSystem, ticket.MessageType)
+	if err != nil {
+		return fmt.Errorf("applyReply: ticket %s: %w", cacheRecord.TicketID, err)
+	}
+	replyStatus, err := r.canonicalStatus(ticket)
+	if err != nil {
+		return fmt.Errorf("applyReply: %w", err)
+	}
+	if r.subscribed(cacheRecord.Source, ticket.MessageType) {
+		err = r.SendEvent(ctx, ticket, cacheRecord, replyStatus)
 		if err != nil {
-			r.lg.Error("ResponseController.CreateTicket", zap.Error(err))
-			return
+			return fmt.Errorf("applyReply: %w", err)
+		}
+	}
+	update.TicketID = cacheRecord.TicketID
+	update.Status = status
+	//Время первого ответа системы по запросу нужно для контроля SLA
+	if ticket.MessageType != model.Create && cacheRecord.FirstResponse == "" {
+		update.FirstResponse = cache.Timestamp(time.Now())
+	}
+	err = r.cache.UpdateCache(ctx, update)
+	if err != nil {
+		return fmt.Errorf("applyReply: %w", err)
+	}
+	cacheRecord.Status = status
+	//Ответ записывается в журнал последним, по журналу повторно доставленный ответ считается обработанным
+	r.recordEvent(ctx, cacheRecord.TicketID, model.FromSystem, ticket)
+	return nil
+}
+
+// Брокер может доставить ответ повторно, если смещение не успело зафиксироваться.
+// Ответ уже обработан, если в журнале запроса есть такой же ответ системы
+func (r *receiver) processed(ctx context.Context, ticketID string, ticket *model.Ticket) (bool, error) {
+	events, err := r.cache.GetHistory(ctx, ticketID)
+	if err != nil {
+		return false, err
+	}
+	reply := model.NewTicketEvent(ticketID, model.FromSystem, ticket)
+	for i := range events {
+		if events[i].Same(reply) {
+			return true, nil
 		}
 	}
-	return
+	return false, nil
 }
 
-func (r *receiver) ReRouteTicket(ctx context.Context, cacheRecord *cache.CacheRecord) {
-	cacheRecord.Status = model.Error
-	cacheRecord.Modified = time.Now().String()
-	cacheRecord.IDChannelOperatorForBilling = r.IDChannelConverter(cacheRecord.IDChannelOperator,
-		cacheRecord.IDChannelOperatorForBilling)
-	err := r.cache.WriteToCache(ctx, cacheRecord)
+// Ответ системы не содержит TicketID, запрос ищется среди запросов клиента: для ответа на create -
+// самый ранний запрос, ожидающий ответа этой системы, для остальных - по номеру запроса в системе.
+// Если клиент не указан или запрос однозначно не определен - по индексу номера запроса в системе
+func (r *receiver) findTicket(ctx context.Context, ticket *model.Ticket) (*cache.CacheRecord, error) {
+	if ticket.CustomerInternalId == "" {
+		return r.findTicketByOperatorTTId(ctx, ticket)
+	}
+	records, err := r.cache.GetTicketsByCustomerID(ctx, ticket.CustomerInternalId)
 	if err != nil {
-		r.lg.Error("ResponseController.ReRouteTicket", zap.Error(err))
-		return
+		return &cache.CacheRecord{}, fmt.Errorf("findTicket: %w", err)
+	}
+	matched := make([]*cache.CacheRecord, 0)
+	for _, record := range records {
+		if ticket.IDChannelOperator != "" && record.IDChannelOperator != ticket.IDChannelOperator {
+			continue
+		}
+		if ticket.MessageType == model.Create {
+			if record.Status != model.Creating && record.Status != model.Error {
+				continue
+			}
+			if ticket.IDChannelOperatorForBilling != "" &&
+				record.IDChannelOperatorForBilling != ticket.IDChannelOperatorForBilling {
+				continue
+			}
+			return record, nil
+		}
+		if ticket.OperatorTTId != "" && record.OperatorTTId != ticket.OperatorTTId {
+			continue
+		}
+		matched = append(matched, record)
+	}
+	switch len(matched) {
+	case 0:
+		return r.findTicketByOperatorTTId(ctx, ticket)
+	case 1:
+		return matched[0], nil
+	}
+	record, err := r.findTicketByOperatorTTId(ctx, ticket)
+	if err != nil {
+		return record, err
+	}
+	if record.TicketID == "" {
+		return record, fmt.Errorf("findTicket: %w", cache.ErrAmbiguous)
+	}
+	return record, nil
+}
+
+func (r *receiver) findTicketByOperatorTTId(ctx context.Context, ticket *model.Ticket) (*cache.CacheRecord, error) {
+	if ticket.OperatorTTId == "" {
+		return &cache.CacheRecord{}, nil
+	}
+	record, err := r.cache.GetFromCacheByOperatorTTId(ctx, ticket.OperatorTTId)
+	if err != nil {
+		return record, fmt.Errorf("findTicket: %w", err)
+	}
+	return record, nil
+}
+
+// Текущая система считается отказавшей, запрос направляется в следующую из списка кандидатов.
+// Если кандидатов не осталось - запрос отклоняется
+func (r *receiver) ReRouteTicket(ctx context.Context, cacheRecord *cache.CacheRecord) error {
+	//Записи, созданные до сохранения списка кандидатов
+	if cacheRecord.Candidates == "" {
+		targets, err := r.routes.Route(cacheRecord.IDChannelOperator, cacheRecord.TTClassification, cacheRecord.Source)
+		if err != nil {
+			r.logger(ctx).Error("ResponseController.ReRouteTicket", zap.String("ticket_id", cacheRecord.TicketID), zap.Error(err))
+		}
+		cacheRecord.Candidates = strings.Join(targets, ",")
+	}
+	cacheRecord.AddDeclined(cacheRecord.IDChannelOperatorForBilling)
+	next, ok := cacheRecord.NextCandidate()
+	if !ok {
+		r.logger(ctx).Info("All candidate ticket systems declined the request",
+			zap.String("ticket_id", cacheRecord.TicketID),
+			zap.String("declined", cacheRecord.Declined))
+		return r.DeclineTicket(ctx, cacheRecord)
+	}
+	status, err := model.Transition(cacheRecord.Status, model.Controller, model.Create)
+	if err != nil {
+		return fmt.Errorf("ResponseController.ReRouteTicket: ticket %s: %w", cacheRecord.TicketID, err)
 	}
 	var ticket = model.Ticket{
 		MessageType:                 model.Create,
-		IDChannelOperatorForBilling: cacheRecord.IDChannelOperatorForBilling,
+		IDChannelOperatorForBilling: next,
 		CustomerInternalId:          cacheRecord.CustomerInternalID,
 		IDChannelOperator:           cacheRecord.IDChannelOperator,
 		Description:                 cacheRecord.Description,
@@ -137,105 +454,138 @@ func (r *receiver) ReRouteTicket(ctx context.Context, cacheRecord *cache.CacheRe
 		FileName:                    cacheRecord.FileName,
 		File:                        cacheRecord.File,
 	}
-	err = r.ticketer.CreateTicket(ctx, &ticket)
+	//Запрос отправляется до записи в кэш: при повторе после сбоя записи он уйдет той же системе, а не следующей
+	err = r.ticketer.CreateTicket(model.WithSource(ctx, cacheRecord.Source), &ticket)
 	if err != nil {
-		r.lg.Error("ResponseController.ReRouteTicket", zap.Error(err))
-		return
+		return fmt.Errorf("ResponseController.ReRouteTicket: %w", err)
 	}
-	return
-}
-
-func (r *receiver) ReopenTicket(ctx context.Context, ticket *model.Ticket) {
-	//todo явно нужно изменить статус тикета. на пути туда или будет ответ?
+	cacheRecord.Status = status
+	cacheRecord.IDChannelOperatorForBilling = next
+	err = r.cache.UpdateCache(ctx, &cache.CacheRecord{
+		TicketID:                    cacheRecord.TicketID,
+		Status:                      cacheRecord.Status,
+		IDChannelOperatorForBilling: cacheRecord.IDChannelOperatorForBilling,
+		Candidates:                  cacheRecord.Candidates,
+		Declined:                    cacheRecord.Declined,
+	})
+	if err != nil {
+		return fmt.Errorf("ResponseController.ReRouteTicket: %w", err)
+	}
+	r.recordEvent(ctx, cacheRecord.TicketID, model.Controller, &ticket)
+	return nil
 }
 
-func (r *receiver) StatusTicket(ctx context.Context, ticket *model.Ticket) {
-	cacheRecord, err := r.cache.GetFromCacheByCustomerID(ctx, ticket.CustomerInternalId)
+// Запрос отклонен (или не получил ответа) всеми системами: уведомляем источник и удаляем запись из кэша
+func (r *receiver) DeclineTicket(ctx context.Context, cacheRecord *cache.CacheRecord) error {
+	_, err := model.Transition(cacheRecord.Status, model.Controller, model.Close)
 	if err != nil {
-		r.lg.Error("responseController.StatusTicket", zap.Error(err))
-		return
+		return fmt.Errorf("responseController.DeclineTicket: ticket %s: %w", cacheRecord.TicketID, err)
 	}
-	if cacheRecord.CustomerInternalID == "" {
-		r.lg.Error("responseController.StatusTicket: no cache record")
-		return
+	r.logger(ctx).Info("Request was declined by all ticket systems",
+		zap.String("ticket_id", cacheRecord.TicketID))
+	var ticket = model.Ticket{
+		MessageType:                 model.Create,
+		IDChannelOperatorForBilling: cacheRecord.IDChannelOperatorForBilling,
+		CustomerInternalId:          cacheRecord.CustomerInternalID,
+		IDChannelOperator:           cacheRecord.IDChannelOperator,
+		Description:                 cacheRecord.Description,
+		TTStartTimeTS:               cacheRecord.TTStartTimeTS,
+		TTClassification:            cacheRecord.TTClassification,
+		TTStatus:                    string(model.Error),
 	}
-	if r.sources[cacheRecord.Source] != "" {
-		err = r.SendEvent(ctx, ticket, cacheRecord.Source)
+	if r.subscribed(cacheRecord.Source, ticket.MessageType) {
+		err = r.SendEvent(ctx, &ticket, cacheRecord, model.Error)
 		if err != nil {
-			r.lg.Error("responseController.StatusTicket", zap.Error(err))
-			return
+			return fmt.Errorf("responseController.DeclineTicket: %w", err)
 		}
 	}
-	return
+	r.recordEvent(ctx, cacheRecord.TicketID, model.Controller, &ticket)
+	err = r.cache.DeleteFromCache(ctx, cacheRecord)
+	if err != nil {
+		return fmt.Errorf("responseController.DeclineTicket: %w", err)
+	}
+	return nil
 }
 
-func (r *receiver) NoteTicket(ctx context.Context, ticket *model.Ticket) {
-	cacheRecord, err := r.cache.GetFromCacheByCustomerID(ctx, ticket.CustomerInternalId)
+func (r *receiver) recordEvent(ctx context.Context, ticketID string, direction model.EventDirection, ticket *model.Ticket) {
+	err := r.cache.AppendHistory(ctx, model.NewTicketEvent(ticketID, direction, ticket))
 	if err != nil {
-		r.lg.Error("responseController.NoteTicket", zap.Error(err))
-		return
+		r.logger(ctx).Error("responseController.recordEvent", zap.Error(err))
 	}
-	if cacheRecord.CustomerInternalID == "" {
-		r.lg.Error("responseController.NoteTicket: no cache record")
-		return
+}
+
+// Переоткрытый системой запрос возвращается в работу, TTL записи в кэше продлевается
+func (r *receiver) ReopenTicket(ctx context.Context, ticket *model.Ticket) error {
+	err := r.reply(ctx, ticket)
+	if errors.Is(err, errNoTicket) {
+		return fmt.Errorf("responseController.ReopenTicket: tt_erth %s: %w", ticket.OperatorTTId, model.ErrReopenExpired)
 	}
-	if r.sources[cacheRecord.Source] != "" {
-		err = r.SendEvent(ctx, ticket, cacheRecord.Source)
-		if err != nil {
-			r.lg.Error("responseController.NoteTicket", zap.Error(err))
-			return
-		}
+	if err != nil {
+		return fmt.Errorf("responseController.ReopenTicket: %w", err)
+	}
+	return nil
+}
+
+func (r *receiver) StatusTicket(ctx context.Context, ticket *model.Ticket) error {
+	err := r.reply(ctx, ticket)
+	if err != nil {
+		return fmt.Errorf("responseController.StatusTicket: %w", err)
 	}
-	return
+	return nil
 }
 
-func (r *receiver) WaitTicket(ctx context.Context, ticket *model.Ticket) {
-	cacheRecord, err := r.cache.GetFromCacheByCustomerID(ctx, ticket.CustomerInternalId)
+func (r *receiver) NoteTicket(ctx context.Context, ticket *model.Ticket) error {
+	err := r.reply(ctx, ticket)
 	if err != nil {
-		r.lg.Error("responseController.WaitTicket", zap.Error(err))
-		return
+		return fmt.Errorf("responseController.NoteTicket: %w", err)
 	}
-	if cacheRecord.CustomerInternalID == "" {
-		r.lg.Error("responseController.WaitTicket: no cache record")
-		return
+	return nil
+}
+
+func (r *receiver) WaitTicket(ctx context.Context, ticket *model.Ticket) error {
+	err := r.reply(ctx, ticket)
+	if err != nil {
+		return fmt.Errorf("responseController.WaitTicket: %w", err)
 	}
-	if r.sources[cacheRecord.Source] != "" {
-		err = r.SendEvent(ctx, ticket, cacheRecord.Source)
-		if err != nil {
-			r.lg.Error("responseController.WaitTicket", zap.Error(err))
-			return
-		}
+	return nil
+}
+
+func (r *receiver) DoneTicket(ctx context.Context, ticket *model.Ticket) error {
+	err := r.reply(ctx, ticket)
+	if err != nil {
+		return fmt.Errorf("responseController.DoneTicket: %w", err)
 	}
-	return
+	return nil
 }
 
-func (r *receiver) DoneTicket(ctx context.Context, ticket *model.Ticket) {
-	cacheRecord, err := r.cache.GetFromCacheByCustomerID(ctx, ticket.CustomerInternalId)
+func (r *receiver) SendEscalation(ctx context.Context, escalation *model.Escalation) error {
+	if !r.subscribed(escalation.Source, sources.Escalation) {
+		return nil
+	}
+	reqBody, err := json.Marshal(escalation)
 	if err != nil {
-		r.lg.Error("responseController.DoneTicket", zap.Error(err))
-		return
+		return fmt.Errorf("responseController.SendEscalation: %w", err)
 	}
-	if cacheRecord.CustomerInternalID == "" {
-		r.lg.Error("responseController.DoneTicket: no cache record")
-		return
+	err = r.postToSource(ctx, escalation.TicketID, escalation.Source, reqBody)
+	if err != nil {
+		return fmt.Errorf("responseController.SendEscalation: %w", err)
 	}
-	cacheRecord.Status = model.Closed
-	cacheRecord.Modified = time.Now().String()
-	err = r.cache.WriteToCache(ctx, cacheRecord)
+	return nil
+}
 
-	if r.sources[cacheRecord.Source] != "" {
-		err = r.SendEvent(ctx, ticket, cacheRecord.Source)
+// status - статус контроллера, источнику передается в его словаре
+func (r *receiver) SendEvent(ctx context.Context, ticket *model.Ticket, cacheRecord *cache.CacheRecord, status model.TTStatus) error {
+	var sourceStatus string
+	if status != "" {
+		var err error
+		sourceStatus, err = r.statuses.ToSource(cacheRecord.Source, status)
 		if err != nil {
-			r.lg.Error("responseController.DoneTicket", zap.Error(err))
-			return
+			r.logger(ctx).Warn("responseController.SendEvent", zap.String("ticket_id", cacheRecord.TicketID), zap.Error(err))
 		}
 	}
-	return
-}
-
-func (r *receiver) SendEvent(ctx context.Context, ticket *model.Ticket, source string) error {
 	var data = model.TicketDTO{
-		Source:                      source,
+		TicketID:                    cacheRecord.TicketID,
+		Source:                      cacheRecord.Source,
 		MessageType:                 ticket.MessageType,
 		CustomerInternalID:          ticket.CustomerInternalId,
 		IDChannelOperatorForBilling: ticket.IDChannelOperatorForBilling,
@@ -247,7 +597,7 @@ func (r *receiver) SendEvent(ctx context.Context, ticket *model.Ticket, source s
 		FileName:                    ticket.FileName,
 		//File:                        base64.Encoding{},
 		OperatorTTId: ticket.OperatorTTId,
-		Status:       ticket.TTStatus, //todo матрица транслируемых в заказчика статусов? так и не согласовали
+		Status:       sourceStatus,
 		Comment:      ticket.Comment,
 		User:         ticket.User,
 	}
@@ -255,23 +605,37 @@ func (r *receiver) SendEvent(ctx context.Context, ticket *model.Ticket, source s
 	if err != nil {
 		return fmt.Errorf("responseController.SendEvent: %w", err)
 	}
-	response, err := http.Post(r.sources[source], "application/json", bytes.NewBuffer(reqBody))
+	err = r.postToSource(ctx, cacheRecord.TicketID, cacheRecord.Source, reqBody)
 	if err != nil {
 		return fmt.Errorf("responseController.SendEvent: %w", err)
 	}
-	defer response.Body.Close()
-	if response.StatusCode != http.StatusOK {
-		return fmt.Errorf("responseController.SendEvent: %s", response.Status)
-	}
 	return nil
 }
 
-func (r *receiver) IDChannelConverter(idChannelOperator, idChannelOperatorForBilling string) (altIDChannelOperatorForBilling string) {
-	if idChannelOperatorForBilling == "KRUS" {
-		var re = regexp.MustCompile(`(\d{2})(\d{2}-.+)`)
-		parts := re.FindStringSubmatch(idChannelOperator)
-		altIDChannelOperatorForBilling = fmt.Sprintf("RIAS_%s", parts[1])
-		return altIDChannelOperatorForBilling
+// Статус ответа системы в статусе контроллера. Нераспознанный статус учитывается в /admin/statuses/unmapped,
+// ответ с ним не обрабатывается: ошибка постоянная, ответ уходит в dead-letter топик
+func (r *receiver) canonicalStatus(ticket *model.Ticket) (model.TTStatus, error) {
+	if ticket.TTStatus == "" {
+		return "", nil
+	}
+	status, err := r.statuses.FromSystem(ticket.IDChannelOperatorForBilling, ticket.TTStatus)
+	if err != nil {
+		return "", fmt.Errorf("canonicalStatus: ticket %s: %w", ticket.OperatorTTId, err)
+	}
+	return status, nil
+}
+
+// Событие отправляется, если источник зарегистрирован, включен, принимает этот тип и задан адрес
+func (r *receiver) subscribed(name string, messageType model.RequestType) bool {
+	source, err := r.sources.Check(name, messageType)
+	return err == nil && source.CallbackURL != ""
+}
+
+// Событие сохраняется в outbox, доставка с повторами выполняется асинхронно
+func (r *receiver) postToSource(ctx context.Context, ticketID, name string, reqBody []byte) error {
+	source, ok := r.sources.Get(name)
+	if !ok {
+		return fmt.Errorf("%w: %q", sources.ErrUnknownSource, name)
 	}
-	return "KRUS"
+	return r.outbox.Enqueue(ctx, ticketID, name, source.CallbackURL, reqBody)
 }
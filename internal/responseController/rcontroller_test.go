@@ -0,0 +1,411 @@
+package responseController
+
+import (
+	"TController/internal/cache"
+	"TController/internal/model"
+	"TController/internal/outbox"
+	"TController/internal/routing"
+	"TController/internal/sources"
+	"TController/internal/statuses"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Кэш в памяти, у которого первые updateErrors вызовов UpdateCache завершаются ошибкой
+type failingCache struct {
+	cache.Cache
+	mu           sync.Mutex
+	updateErrors int
+	updates      int
+}
+
+func (f *failingCache) UpdateCache(ctx context.Context, record *cache.CacheRecord) error {
+	f.mu.Lock()
+	f.updates++
+	fail := f.updates <= f.updateErrors
+	f.mu.Unlock()
+	if fail {
+		return errors.New("cache is unavailable")
+	}
+	return f.Cache.UpdateCache(ctx, record)
+}
+
+type fakeTicketer struct {
+	mu      sync.Mutex
+	created []model.Ticket
+}
+
+func (f *fakeTicketer) CreateTicket(ctx context.Context, ticket *model.Ticket) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.created = append(f.created, *ticket)
+	return nil
+}
+
+func (f *fakeTicketer) ReopenTicket(ctx context.Context, ticket *model.Ticket) error {
+	return nil
+}
+
+func (f *fakeTicketer) ChangeTicketStatus(ctx context.Context, ticket *model.Ticket) error {
+	return nil
+}
+
+func (f *fakeTicketer) CheckTicketStatus(ctx context.Context, ticket *model.Ticket) error {
+	return nil
+}
+
+func (f *fakeTicketer) AddNoteToTicket(ctx context.Context, ticket *model.Ticket) error {
+	return nil
+}
+
+func (f *fakeTicketer) CloseTicket(ctx context.Context, ticket *model.Ticket) error {
+	return nil
+}
+
+func newTestReceiver(t *testing.T, c cache.Cache) *receiver {
+	registry, err := sources.NewRegistry([]sources.Source{{Name: "api", CallbackURL: "http://source.local/events", Enabled: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	translator, err := statuses.LoadMatrix("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	router, err := routing.NewRouter([]routing.Rule{{Name: "all", Targets: []string{"KRUS", "RIAS_01"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhooks := outbox.NewOutbox(context.Background(), outbox.NewMemoryStore(zap.NewNop()), registry, outbox.Config{}, zap.NewNop())
+	return NewReceiver(context.Background(), make(chan *model.Message), c, &fakeTicketer{}, webhooks, registry, translator, router, 3600, zap.NewNop()).(*receiver)
+}
+
+func writeTicket(t *testing.T, c cache.Cache, record cache.CacheRecord) {
+	record.Source = "api"
+	record.Created = cache.Timestamp(time.Now())
+	record.Candidates = "KRUS,RIAS_01"
+	err := c.WriteToCache(context.Background(), &record)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Сообщение из брокера: фиксирует вызовы Done и DeadLetter
+type testMessage struct {
+	message    *model.Message
+	done       bool
+	deadLetter error
+}
+
+func newTestMessage(id string, ticket model.Ticket, dlqErr error) *testMessage {
+	m := &testMessage{}
+	m.message = &model.Message{
+		ID:     id,
+		Ticket: &ticket,
+		Done:   func() { m.done = true },
+		DeadLetter: func(ctx context.Context, cause error) error {
+			m.deadLetter = cause
+			return dlqErr
+		},
+	}
+	return m
+}
+
+// Обрабатывает сообщения одним обработчиком до закрытия канала
+func receive(r *receiver, messages ...*testMessage) {
+	out := make(chan *model.Message, len(messages))
+	for _, m := range messages {
+		out <- m.message
+	}
+	close(out)
+	r.ResponseReceiver(out, 1)
+}
+
+func TestResponseReceiverErrors(t *testing.T) {
+	defer func(backoff time.Duration) { handleBackoff = backoff }(handleBackoff)
+	handleBackoff = time.Millisecond
+	reply := model.Ticket{MessageType: model.Note, CustomerInternalId: "C1", OperatorTTId: "TT-1", EventTimestamp: 1}
+	tests := []struct {
+		name         string
+		reply        model.Ticket
+		updateErrors int
+		dlqErr       error
+		done         bool
+		deadLetter   bool
+		updates      int
+	}{
+		{name: "processed", reply: reply, done: true, updates: 1},
+		{name: "transient error retried", reply: reply, updateErrors: 2, done: true, updates: 3},
+		{name: "retries exhausted", reply: reply, updateErrors: 100, done: true, deadLetter: true, updates: handleAttempts},
+		{name: "unknown ticket dead-lettered without retries", reply: model.Ticket{MessageType: model.Note, OperatorTTId: "unknown"}, done: true, deadLetter: true},
+		{name: "illegal transition", reply: model.Ticket{MessageType: model.Reopen, CustomerInternalId: "C1", OperatorTTId: "TT-1"}, done: true, deadLetter: true},
+		{name: "wrong message type", reply: model.Ticket{MessageType: "unknown", OperatorTTId: "TT-1"}, done: true, deadLetter: true},
+		{name: "dead-letter write failed", reply: reply, updateErrors: 100, dlqErr: errors.New("broker is down"), deadLetter: true, updates: handleAttempts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &failingCache{Cache: cache.NewMemoryCache(3600, zap.NewNop()), updateErrors: tt.updateErrors}
+			writeTicket(t, c, cache.CacheRecord{TicketID: "T1", CustomerInternalID: "C1", OperatorTTId: "TT-1", IDChannelOperatorForBilling: "KRUS", Status: model.Working})
+			r := newTestReceiver(t, c)
+			m := newTestMessage("m1", tt.reply, tt.dlqErr)
+			receive(r, m)
+			if m.done != tt.done {
+				t.Errorf("done = %v, want %v", m.done, tt.done)
+			}
+			if (m.deadLetter != nil) != tt.deadLetter {
+				t.Errorf("dead letter = %v, want %v", m.deadLetter, tt.deadLetter)
+			}
+			if c.updates != tt.updates {
+				t.Errorf("UpdateCache called %d times, want %d", c.updates, tt.updates)
+			}
+		})
+	}
+}
+
+// Остановка контроллера прерывает ожидание повтора: ответ не фиксируется и не уходит в dead-letter топик
+func TestRetryStopsOnShutdown(t *testing.T) {
+	defer func(backoff time.Duration) { handleBackoff = backoff }(handleBackoff)
+	handleBackoff = time.Minute
+	c := &failingCache{Cache: cache.NewMemoryCache(3600, zap.NewNop()), updateErrors: 100}
+	writeTicket(t, c, cache.CacheRecord{TicketID: "T1", CustomerInternalID: "C1", OperatorTTId: "TT-1", IDChannelOperatorForBilling: "KRUS", Status: model.Working})
+	r := newTestReceiver(t, c)
+	ctx, stop := context.WithCancel(context.Background())
+	r.ctx = ctx
+	time.AfterFunc(50*time.Millisecond, stop)
+	m := newTestMessage("m1", model.Ticket{MessageType: model.Note, CustomerInternalId: "C1", OperatorTTId: "TT-1", EventTimestamp: 1}, nil)
+	started := time.Now()
+	receive(r, m)
+	if elapsed := time.Since(started); elapsed > 10*time.Second {
+		t.Fatalf("receiver stopped after %v", elapsed)
+	}
+	if m.done || m.deadLetter != nil || c.updates != 1 {
+		t.Fatalf("done = %v, dead letter = %v, updates = %d", m.done, m.deadLetter, c.updates)
+	}
+}
+
+func TestDuplicateReplies(t *testing.T) {
+	defer func(backoff time.Duration) { handleBackoff = backoff }(handleBackoff)
+	handleBackoff = time.Millisecond
+	note := model.Ticket{MessageType: model.Note, CustomerInternalId: "C1", OperatorTTId: "TT-1", EventTimestamp: 1, Comment: "first"}
+	other := note
+	other.EventTimestamp = 2
+	tests := []struct {
+		name         string
+		ids          []string
+		replies      []model.Ticket
+		updateErrors int //ошибки первой обработки: после исчерпания повторов ответ уходит в dead-letter
+		dropped      int64
+		history      int
+	}{
+		{name: "same message id", ids: []string{"m1", "m1"}, replies: []model.Ticket{note, note}, dropped: 1, history: 1},
+		{name: "same content without id", ids: []string{"", ""}, replies: []model.Ticket{note, note}, dropped: 1, history: 1},
+		{name: "same content with new id", ids: []string{"m1", "m2"}, replies: []model.Ticket{note, note}, history: 1},
+		{name: "different replies", ids: []string{"", ""}, replies: []model.Ticket{note, other}, history: 2},
+		{name: "dead-lettered reply is not marked seen", ids: []string{"m1", "m1"}, replies: []model.Ticket{note, note}, updateErrors: handleAttempts, history: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &failingCache{Cache: cache.NewMemoryCache(3600, zap.NewNop()), updateErrors: tt.updateErrors}
+			writeTicket(t, c, cache.CacheRecord{TicketID: "T1", CustomerInternalID: "C1", OperatorTTId: "TT-1", IDChannelOperatorForBilling: "KRUS", Status: model.Working})
+			r := newTestReceiver(t, c)
+			messages := make([]*testMessage, len(tt.replies))
+			for i := range tt.replies {
+				messages[i] = newTestMessage(tt.ids[i], tt.replies[i], nil)
+			}
+			receive(r, messages...)
+			for i, m := range messages {
+				if !m.done {
+					t.Errorf("message %d is not committed", i)
+				}
+			}
+			if r.dropped != tt.dropped {
+				t.Errorf("dropped = %d, want %d", r.dropped, tt.dropped)
+			}
+			history, err := c.GetHistory(context.Background(), "T1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(history) != tt.history {
+				t.Errorf("history has %d events, want %d", len(history), tt.history)
+			}
+		})
+	}
+}
+
+func TestCreateErrorRerouteOnce(t *testing.T) {
+	c := cache.NewMemoryCache(3600, zap.NewNop())
+	writeTicket(t, c, cache.CacheRecord{TicketID: "T1", CustomerInternalID: "C1", IDChannelOperatorForBilling: "KRUS", Status: model.Creating})
+	r := newTestReceiver(t, c)
+	declined := model.Ticket{MessageType: model.Create, CustomerInternalId: "C1", TTStatus: string(model.Error), EventTimestamp: 1}
+	//Разные идентификаторы сообщений: повтор не отсеивается по ключу, только по журналу
+	receive(r, newTestMessage("m1", declined, nil), newTestMessage("m2", declined, nil))
+
+	created := r.ticketer.(*fakeTicketer).created
+	if len(created) != 1 || created[0].IDChannelOperatorForBilling != "RIAS_01" {
+		t.Fatalf("rerouted: %+v", created)
+	}
+	record, _ := c.GetFromCacheByTicketID(context.Background(), "T1")
+	if record.Declined != "KRUS" || record.Status != model.Error {
+		t.Fatalf("record: declined %q, status %s", record.Declined, record.Status)
+	}
+}
+
+// Отказ системы с нераспознанным статусом не принимается как успешное заведение
+func TestUnmappedStatusDeadLettered(t *testing.T) {
+	tests := []struct {
+		name   string
+		status model.TTStatus
+		reply  model.Ticket
+	}{
+		{name: "create", status: model.Creating, reply: model.Ticket{MessageType: model.Create, CustomerInternalId: "C1", IDChannelOperatorForBilling: "KRUS", TTStatus: "rejected", EventTimestamp: 1}},
+		{name: "status", status: model.Working, reply: model.Ticket{MessageType: model.Status, CustomerInternalId: "C1", OperatorTTId: "TT-1", IDChannelOperatorForBilling: "KRUS", TTStatus: "rejected", EventTimestamp: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cache.NewMemoryCache(3600, zap.NewNop())
+			writeTicket(t, c, cache.CacheRecord{TicketID: "T1", CustomerInternalID: "C1", OperatorTTId: "TT-1", IDChannelOperatorForBilling: "KRUS", Status: tt.status})
+			r := newTestReceiver(t, c)
+			m := newTestMessage("m1", tt.reply, nil)
+			receive(r, m)
+			if !m.done || !errors.Is(m.deadLetter, statuses.ErrUnmapped) {
+				t.Fatalf("done = %v, dead letter = %v", m.done, m.deadLetter)
+			}
+			record, _ := c.GetFromCacheByTicketID(context.Background(), "T1")
+			if record.Status != tt.status {
+				t.Fatalf("status = %s, want %s", record.Status, tt.status)
+			}
+			unmapped := r.statuses.GetUnmapped()
+			if len(unmapped) != 1 || unmapped[0].Kind != statuses.SystemToController || unmapped[0].Name != "KRUS" || unmapped[0].Value != "rejected" {
+				t.Fatalf("unmapped %+v", unmapped)
+			}
+		})
+	}
+}
+
+// Outbox, который запоминает поставленные в очередь события
+type recordingOutbox struct {
+	outbox.Outbox
+	mu     sync.Mutex
+	events []string //ticketID/source
+}
+
+func (o *recordingOutbox) Enqueue(ctx context.Context, ticketID, source, uri string, body []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, ticketID+"/"+source)
+	return nil
+}
+
+// Переоткрытый системой закрытый запрос возвращается в работу, TTL записи продлевается, источник получает событие.
+// Запрос, который уже удален из кэша, переоткрыть нельзя: ответ уходит в dead-letter топик
+func TestReopenTicket(t *testing.T) {
+	const ttl = 1
+	reopen := model.Ticket{MessageType: model.Reopen, OperatorTTId: "TT-1", EventTimestamp: 1}
+	tests := []struct {
+		name       string
+		cached     bool
+		deadLetter error
+		status     model.TTStatus
+		events     int
+	}{
+		{name: "reopened", cached: true, status: model.Working, events: 1},
+		{name: "expired", deadLetter: model.ErrReopenExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cache.NewMemoryCache(ttl, zap.NewNop())
+			if tt.cached {
+				writeTicket(t, c, cache.CacheRecord{TicketID: "T1", CustomerInternalID: "C1", OperatorTTId: "TT-1", IDChannelOperatorForBilling: "KRUS", Status: model.Closed})
+			}
+			r := newTestReceiver(t, c)
+			webhooks := &recordingOutbox{}
+			r.outbox = webhooks
+			//Ответ приходит во второй половине TTL записи: без продления она истечет до проверки
+			if tt.cached {
+				time.Sleep(ttl * time.Second * 6 / 10)
+			}
+			m := newTestMessage("m1", reopen, nil)
+			receive(r, m)
+			if !m.done || !errors.Is(m.deadLetter, tt.deadLetter) {
+				t.Fatalf("done = %v, dead letter = %v, want %v", m.done, m.deadLetter, tt.deadLetter)
+			}
+			if len(webhooks.events) != tt.events {
+				t.Fatalf("enqueued %v, want %d events", webhooks.events, tt.events)
+			}
+			if !tt.cached {
+				return
+			}
+			time.Sleep(ttl * time.Second * 6 / 10)
+			record, err := c.GetFromCacheByTicketID(context.Background(), "T1")
+			if err != nil {
+				t.Fatalf("record expired after reopen: %v", err)
+			}
+			if record.Status != tt.status || webhooks.events[0] != "T1/api" {
+				t.Fatalf("status = %s, events %v", record.Status, webhooks.events)
+			}
+		})
+	}
+}
+
+func TestShardIgnoresCustomer(t *testing.T) {
+	for _, n := range []int{1, 3, 8} {
+		for i := 0; i < 50; i++ {
+			operatorTTId := fmt.Sprintf("TT-%d", i)
+			withCustomer := shard(&model.Ticket{OperatorTTId: operatorTTId, CustomerInternalId: fmt.Sprintf("C%d", i)}, n)
+			withoutCustomer := shard(&model.Ticket{OperatorTTId: operatorTTId}, n)
+			if withCustomer != withoutCustomer {
+				t.Fatalf("%s: shard %d with customer, %d without", operatorTTId, withCustomer, withoutCustomer)
+			}
+		}
+	}
+}
+
+// Ответы по одному запросу, с клиентом и без, обрабатываются в порядке получения
+func TestPerTicketOrderAcrossShards(t *testing.T) {
+	const tickets, replies = 8, 20
+	c := cache.NewMemoryCache(3600, zap.NewNop())
+	for i := 0; i < tickets; i++ {
+		writeTicket(t, c, cache.CacheRecord{
+			TicketID:                    fmt.Sprintf("T%d", i),
+			CustomerInternalID:          fmt.Sprintf("C%d", i),
+			OperatorTTId:                fmt.Sprintf("TT-%d", i),
+			IDChannelOperatorForBilling: "KRUS",
+			Status:                      model.Working,
+		})
+	}
+	r := newTestReceiver(t, c)
+	var wg sync.WaitGroup
+	r.InitReceiversPull(4)
+	for j := 1; j <= replies; j++ {
+		for i := 0; i < tickets; i++ {
+			ticket := model.Ticket{MessageType: model.Note, OperatorTTId: fmt.Sprintf("TT-%d", i), EventTimestamp: int64(j)}
+			if j%2 == 0 {
+				ticket.CustomerInternalId = fmt.Sprintf("C%d", i)
+			}
+			wg.Add(1)
+			r.out <- &model.Message{ID: fmt.Sprintf("%d-%d", i, j), Ticket: &ticket, Done: wg.Done}
+		}
+	}
+	wg.Wait()
+	close(r.out)
+
+	for i := 0; i < tickets; i++ {
+		history, err := c.GetHistory(context.Background(), fmt.Sprintf("T%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != replies {
+			t.Fatalf("T%d: %d events", i, len(history))
+		}
+		for j := range history {
+			if history[j].EventTimeTS != int64(j+1) {
+				t.Fatalf("T%d: event %d has timestamp %d", i, j, history[j].EventTimeTS)
+			}
+		}
+	}
+}
@@ -1,9 +1,15 @@
 package responseController
 
-import "TController/internal/model"
+import (
+	"TController/internal/cache"
+	"TController/internal/model"
+	"context"
+)
 
 type Response interface {
 	InitReceiversPull(n int)
-	AddSource(name, uri string)
-	ResponseReceiver(out chan *model.Ticket, id int)
+	ResponseReceiver(out chan *model.Message, id int)
+	ReRouteTicket(ctx context.Context, cacheRecord *cache.CacheRecord) error
+	DeclineTicket(ctx context.Context, cacheRecord *cache.CacheRecord) error
+	SendEscalation(ctx context.Context, escalation *model.Escalation) error
 }
@@ -0,0 +1,130 @@
+package routing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+)
+
+var ErrNoRoute = errors.New("no routing rule matches ticket")
+
+// Правило маршрутизации. Пустой шаблон подходит под любое значение.
+// Targets - системы в порядке приоритета, в них подставляются группы шаблона IDChannelOperator: $1, ${name}
+type Rule struct {
+	Name              string   `json:"name"`
+	IDChannelOperator string   `json:"id_channel_operator,omitempty"`
+	TTClassification  string   `json:"problem_type,omitempty"`
+	Source            string   `json:"source,omitempty"`
+	Targets           []string `json:"targets"`
+}
+
+// Правила по умолчанию повторяют прежний разбор IDChannelOperator:
+// 4 буквы и 2 цифры - RIAS_<цифры>, резерв KRUS; 3 буквы и 4 цифры - KRUS, резерв RIAS_<первые 2 цифры>
+var DefaultRules = []Rule{
+	{
+		Name:              "rias",
+		IDChannelOperator: `^[a-zA-Z]{4}(\d{2})-.+`,
+		Targets:           []string{"RIAS_$1", "KRUS"},
+	},
+	{
+		Name:              "krus",
+		IDChannelOperator: `^[a-zA-Z]{3}(\d{2})\d{2}-.+`,
+		Targets:           []string{"KRUS", "RIAS_$1"},
+	},
+}
+
+type Router interface {
+	//Системы для запроса в порядке приоритета по первому подходящему правилу
+	Route(idChannelOperator, ttClassification, source string) ([]string, error)
+}
+
+type rule struct {
+	Rule
+	idChannelOperator *regexp.Regexp
+	ttClassification  *regexp.Regexp
+	source            *regexp.Regexp
+}
+
+type router struct {
+	rules []rule
+}
+
+func NewRouter(rules []Rule) (Router, error) {
+	r := &router{rules: make([]rule, 0, len(rules))}
+	for i, item := range rules {
+		if len(item.Targets) == 0 {
+			return nil, fmt.Errorf("routing.NewRouter: rule %d %q: targets are empty", i, item.Name)
+		}
+		compiled := rule{Rule: item}
+		var err error
+		compiled.idChannelOperator, err = compile(item.IDChannelOperator)
+		if err != nil {
+			return nil, fmt.Errorf("routing.NewRouter: rule %d %q: %w", i, item.Name, err)
+		}
+		compiled.ttClassification, err = compile(item.TTClassification)
+		if err != nil {
+			return nil, fmt.Errorf("routing.NewRouter: rule %d %q: %w", i, item.Name, err)
+		}
+		compiled.source, err = compile(item.Source)
+		if err != nil {
+			return nil, fmt.Errorf("routing.NewRouter: rule %d %q: %w", i, item.Name, err)
+		}
+		r.rules = append(r.rules, compiled)
+	}
+	return r, nil
+}
+
+func compile(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(pattern)
+}
+
+// Правила из JSON-файла path, пустой path - DefaultRules
+func LoadRules(path string) (Router, error) {
+	if path == "" {
+		return NewRouter(DefaultRules)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("routing.LoadRules: %w", err)
+	}
+	//Правила файла разбираются в новый срез: незаполненные поля не берутся из DefaultRules
+	var rules []Rule
+	err = json.Unmarshal(data, &rules)
+	if err != nil {
+		return nil, fmt.Errorf("routing.LoadRules: %w", err)
+	}
+	router, err := NewRouter(rules)
+	if err != nil {
+		return nil, fmt.Errorf("routing.LoadRules: %w", err)
+	}
+	return router, nil
+}
+
+func (r *router) Route(idChannelOperator, ttClassification, source string) ([]string, error) {
+	for _, rule := range r.rules {
+		if rule.ttClassification != nil && !rule.ttClassification.MatchString(ttClassification) {
+			continue
+		}
+		if rule.source != nil && !rule.source.MatchString(source) {
+			continue
+		}
+		if rule.idChannelOperator == nil {
+			return append([]string(nil), rule.Targets...), nil
+		}
+		match := rule.idChannelOperator.FindStringSubmatchIndex(idChannelOperator)
+		if match == nil {
+			continue
+		}
+		targets := make([]string, 0, len(rule.Targets))
+		for _, target := range rule.Targets {
+			targets = append(targets, string(rule.idChannelOperator.ExpandString(nil, target, idChannelOperator, match)))
+		}
+		return targets, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrNoRoute, idChannelOperator)
+}
@@ -0,0 +1,119 @@
+package routing
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRoute(t *testing.T) {
+	router, err := NewRouter([]Rule{
+		{Name: "vip", Source: `^vip$`, Targets: []string{"VIP"}},
+		{Name: "network", TTClassification: `^network$`, IDChannelOperator: `^(?P<region>\d{2})-`, Targets: []string{"NET_${region}", "KRUS"}},
+		{Name: "rias", IDChannelOperator: `^[a-zA-Z]{4}(\d{2})-.+`, Targets: []string{"RIAS_$1", "KRUS"}},
+		{Name: "krus", IDChannelOperator: `^[a-zA-Z]{3}(\d{2})\d{2}-.+`, Targets: []string{"KRUS", "RIAS_$1"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name              string
+		idChannelOperator string
+		ttClassification  string
+		source            string
+		want              []string
+		err               error
+	}{
+		{name: "source without pattern on channel", idChannelOperator: "anything", source: "vip", want: []string{"VIP"}},
+		{name: "named group", idChannelOperator: "42-abc", ttClassification: "network", want: []string{"NET_42", "KRUS"}},
+		{name: "classification mismatch skips rule", idChannelOperator: "42-abc", ttClassification: "other", err: ErrNoRoute},
+		{name: "rias capture", idChannelOperator: "abcd12-x", want: []string{"RIAS_12", "KRUS"}},
+		{name: "krus capture", idChannelOperator: "abc1234-x", want: []string{"KRUS", "RIAS_12"}},
+		{name: "no match", idChannelOperator: "12345", err: ErrNoRoute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := router.Route(tt.idChannelOperator, tt.ttClassification, tt.source)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("targets = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteReturnsCopy(t *testing.T) {
+	router, err := NewRouter([]Rule{{Name: "all", Targets: []string{"A", "B"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := router.Route("", "", "")
+	got[0] = "changed"
+	again, _ := router.Route("", "", "")
+	if again[0] != "A" {
+		t.Fatalf("rule targets were modified through result: %v", again)
+	}
+}
+
+func TestNewRouterErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []Rule
+	}{
+		{name: "empty targets", rules: []Rule{{Name: "x"}}},
+		{name: "bad pattern", rules: []Rule{{Name: "x", IDChannelOperator: "(", Targets: []string{"A"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRouter(tt.rules)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	defaults := make([]Rule, len(DefaultRules))
+	copy(defaults, DefaultRules)
+
+	dir, err := ioutil.TempDir("", "routing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rules.json")
+	err = ioutil.WriteFile(path, []byte(`[{"name":"catchall","targets":["SYS_A"]}]`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router, err := LoadRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//Поля, которых нет в файле, не берутся из правил по умолчанию
+	got, err := router.Route("no-default-pattern-matches", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []string{"SYS_A"}) {
+		t.Fatalf("targets = %v", got)
+	}
+	if !reflect.DeepEqual(DefaultRules, defaults) {
+		t.Fatalf("DefaultRules modified: %+v", DefaultRules)
+	}
+
+	router, err = LoadRules("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = router.Route("abcd12-x", "", "")
+	if err != nil || !reflect.DeepEqual(got, []string{"RIAS_12", "KRUS"}) {
+		t.Fatalf("default rules: %v, %v", got, err)
+	}
+}
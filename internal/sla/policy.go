@@ -0,0 +1,110 @@
+package sla
+
+import (
+	"TController/internal/cache"
+	"TController/internal/model"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// Пустое поле условия подходит под любое значение, System сравнивается по префиксу ("RIAS_" - все RIAS)
+type Policy struct {
+	Name             string         `json:"name"`
+	TTClassification string         `json:"problem_type,omitempty"`
+	Source           string         `json:"source,omitempty"`
+	System           string         `json:"system,omitempty"`
+	Acknowledgement  model.Duration `json:"acknowledgement,omitempty"`
+	FirstResponse    model.Duration `json:"first_response,omitempty"`
+	Resolution       model.Duration `json:"resolution,omitempty"`
+}
+
+type Breach struct {
+	Policy   *Policy
+	Deadline Deadline
+	Due      time.Time
+}
+
+type policies struct {
+	policies      []Policy
+	defaultPolicy Policy
+}
+
+func NewPolicies(list []Policy, defaultPolicy Policy) Policies {
+	return &policies{policies: list, defaultPolicy: defaultPolicy}
+}
+
+// Политики проверяются в порядке описания в файле, при отсутствии совпадений используется политика по умолчанию
+func LoadPolicies(path string, defaultPolicy Policy) (Policies, error) {
+	if path == "" {
+		return NewPolicies(nil, defaultPolicy), nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("sla.LoadPolicies: %w", err)
+	}
+	var list []Policy
+	err = json.Unmarshal(data, &list)
+	if err != nil {
+		return nil, fmt.Errorf("sla.LoadPolicies: %w", err)
+	}
+	return NewPolicies(list, defaultPolicy), nil
+}
+
+func (p *policies) Match(record *cache.CacheRecord) *Policy {
+	for i := range p.policies {
+		policy := &p.policies[i]
+		if policy.TTClassification != "" && policy.TTClassification != record.TTClassification {
+			continue
+		}
+		if policy.Source != "" && policy.Source != record.Source {
+			continue
+		}
+		if policy.System != "" && !strings.HasPrefix(record.IDChannelOperatorForBilling, policy.System) {
+			continue
+		}
+		return policy
+	}
+	return &p.defaultPolicy
+}
+
+// Нулевой срок в политике означает, что срок не контролируется
+func (p *policies) Breaches(record *cache.CacheRecord, now time.Time) ([]Breach, error) {
+	breaches := make([]Breach, 0)
+	if record.Status == model.Closed {
+		return breaches, nil
+	}
+	policy := p.Match(record)
+	if policy.Acknowledgement == 0 && policy.FirstResponse == 0 && policy.Resolution == 0 {
+		return breaches, nil
+	}
+	created, err := cache.ParseTimestamp(record.Created)
+	if err != nil {
+		return breaches, fmt.Errorf("sla.Breaches: %w", err)
+	}
+
+	//Срок принятия считается от создания запроса: перевод в другую систему его не продлевает
+	//и повторного уведомления не вызывает
+	waiting := record.Status == model.Creating || record.Status == model.Error
+	if waiting && record.Acknowledged == "" && policy.Acknowledgement > 0 && !record.Escalated(string(Acknowledgement)) {
+		due := created.Add(time.Duration(policy.Acknowledgement))
+		if now.After(due) {
+			breaches = append(breaches, Breach{Policy: policy, Deadline: Acknowledgement, Due: due})
+		}
+	}
+	if record.FirstResponse == "" && policy.FirstResponse > 0 && !record.Escalated(string(FirstResponse)) {
+		due := created.Add(time.Duration(policy.FirstResponse))
+		if now.After(due) {
+			breaches = append(breaches, Breach{Policy: policy, Deadline: FirstResponse, Due: due})
+		}
+	}
+	if policy.Resolution > 0 && !record.Escalated(string(Resolution)) {
+		due := created.Add(time.Duration(policy.Resolution))
+		if now.After(due) {
+			breaches = append(breaches, Breach{Policy: policy, Deadline: Resolution, Due: due})
+		}
+	}
+	return breaches, nil
+}
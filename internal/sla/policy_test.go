@@ -0,0 +1,103 @@
+package sla
+
+import (
+	"TController/internal/cache"
+	"TController/internal/model"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMatch(t *testing.T) {
+	policies := NewPolicies([]Policy{
+		{Name: "network-rias", TTClassification: "network", System: "RIAS_"},
+		{Name: "sber", Source: "sber"},
+	}, Policy{Name: "default"})
+	tests := []struct {
+		record cache.CacheRecord
+		want   string
+	}{
+		{cache.CacheRecord{TTClassification: "network", IDChannelOperatorForBilling: "RIAS_12"}, "network-rias"},
+		{cache.CacheRecord{TTClassification: "network", IDChannelOperatorForBilling: "KRUS", Source: "sber"}, "sber"},
+		{cache.CacheRecord{TTClassification: "network", IDChannelOperatorForBilling: "KRUS"}, "default"},
+	}
+	for _, tt := range tests {
+		if got := policies.Match(&tt.record).Name; got != tt.want {
+			t.Errorf("Match(%+v) = %s, want %s", tt.record, got, tt.want)
+		}
+	}
+}
+
+func TestBreaches(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ago := func(d time.Duration) string { return cache.Timestamp(now.Add(-d)) }
+	policy := Policy{
+		Name:            "p",
+		Acknowledgement: model.Duration(30 * time.Minute),
+		FirstResponse:   model.Duration(2 * time.Hour),
+		Resolution:      model.Duration(24 * time.Hour),
+	}
+	tests := []struct {
+		name   string
+		policy Policy
+		record cache.CacheRecord
+		want   []Deadline
+	}{
+		{
+			name:   "in time",
+			policy: policy,
+			record: cache.CacheRecord{Status: model.Creating, Created: ago(time.Minute), Modified: ago(time.Minute)},
+		},
+		{
+			name:   "acknowledgement counted from creation after reroute",
+			policy: policy,
+			record: cache.CacheRecord{Status: model.Creating, Created: ago(time.Hour), Modified: ago(time.Minute)},
+			want:   []Deadline{Acknowledgement},
+		},
+		{
+			name:   "acknowledgement reported once",
+			policy: policy,
+			record: cache.CacheRecord{Status: model.Error, Created: ago(time.Hour), Modified: ago(time.Hour), Escalations: "acknowledgement"},
+		},
+		{
+			name:   "acknowledgement not controlled",
+			policy: Policy{Name: "p"},
+			record: cache.CacheRecord{Status: model.Creating, Created: ago(time.Hour), Modified: ago(time.Hour)},
+		},
+		{
+			name:   "acknowledged ticket",
+			policy: policy,
+			record: cache.CacheRecord{Status: model.Working, Created: ago(3 * time.Hour), Acknowledged: ago(2 * time.Hour)},
+			want:   []Deadline{FirstResponse},
+		},
+		{
+			name:   "all deadlines",
+			policy: policy,
+			record: cache.CacheRecord{Status: model.Creating, Created: ago(25 * time.Hour), Modified: ago(time.Minute)},
+			want:   []Deadline{Acknowledgement, FirstResponse, Resolution},
+		},
+		{
+			name:   "closed ticket",
+			policy: policy,
+			record: cache.CacheRecord{Status: model.Closed, Created: ago(25 * time.Hour)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breaches, err := NewPolicies(nil, tt.policy).Breaches(&tt.record, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]Deadline, 0)
+			for _, breach := range breaches {
+				got = append(got, breach.Deadline)
+			}
+			if len(tt.want) == 0 {
+				tt.want = []Deadline{}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("breaches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
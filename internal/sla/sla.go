@@ -0,0 +1,19 @@
+package sla
+
+import (
+	"TController/internal/cache"
+	"time"
+)
+
+type Deadline string
+
+const (
+	Acknowledgement Deadline = "acknowledgement" //система не приняла запрос (нет ответа на create)
+	FirstResponse   Deadline = "first_response"  //нет первого ответа по принятому запросу
+	Resolution      Deadline = "resolution"      //запрос не закрыт
+)
+
+type Policies interface {
+	Match(record *cache.CacheRecord) *Policy
+	Breaches(record *cache.CacheRecord, now time.Time) ([]Breach, error)
+}
@@ -0,0 +1,168 @@
+package sources
+
+import (
+	"TController/internal/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var ErrUnknownSource = errors.New("source is not registered")
+var ErrSourceDisabled = errors.New("source is disabled")
+var ErrMessageTypeNotAllowed = errors.New("message type is not allowed for source")
+
+type AuthMethod string
+
+const (
+	AuthNone   AuthMethod = "none"
+	AuthHMAC   AuthMethod = "hmac"   //подпись событий, см. pkg/webhook
+	AuthBearer AuthMethod = "bearer" //Authorization: Bearer <secret>
+)
+
+// Событие эскалации SLA не является запросом, но фильтруется по message_types так же
+const Escalation model.RequestType = "escalation"
+
+// Источник запросов. Пустой CallbackURL - источник не получает событий,
+// пустой MessageTypes - разрешены все типы
+type Source struct {
+	Name         string              `json:"name"`
+	CallbackURL  string              `json:"callback_url,omitempty"`
+	Auth         AuthMethod          `json:"auth,omitempty"`
+	SecretEnv    string              `json:"secret_env,omitempty"` //переменная окружения с ключом
+	Secret       string              `json:"-"`
+	Timeout      model.Duration      `json:"timeout,omitempty"` //0 - таймаут доставки по умолчанию
+	MessageTypes []model.RequestType `json:"message_types,omitempty"`
+	Enabled      bool                `json:"enabled"`
+}
+
+// Если enabled не указан, источник включен
+func (s *Source) UnmarshalJSON(data []byte) error {
+	type source Source
+	value := source{Enabled: true}
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return err
+	}
+	*s = Source(value)
+	return nil
+}
+
+func (s *Source) Allows(messageType model.RequestType) bool {
+	if len(s.MessageTypes) == 0 {
+		return true
+	}
+	for _, t := range s.MessageTypes {
+		if t == messageType {
+			return true
+		}
+	}
+	return false
+}
+
+type Registry interface {
+	Get(name string) (*Source, bool)
+	//Источник зарегистрирован, включен и принимает messageType
+	Check(name string, messageType model.RequestType) (*Source, error)
+}
+
+type registry struct {
+	sources map[string]*Source
+}
+
+func NewRegistry(list []Source) (Registry, error) {
+	r := &registry{sources: make(map[string]*Source)}
+	for i := range list {
+		source := list[i]
+		if source.Name == "" {
+			return nil, fmt.Errorf("sources.NewRegistry: source %d: name is empty", i)
+		}
+		if _, ok := r.sources[source.Name]; ok {
+			return nil, fmt.Errorf("sources.NewRegistry: source %q is declared twice", source.Name)
+		}
+		switch source.Auth {
+		case "":
+			source.Auth = AuthNone
+		case AuthNone:
+		case AuthHMAC, AuthBearer:
+			if source.Secret == "" {
+				return nil, fmt.Errorf("sources.NewRegistry: source %q: %s auth requires a secret", source.Name, source.Auth)
+			}
+		default:
+			return nil, fmt.Errorf("sources.NewRegistry: source %q: unknown auth method %q", source.Name, source.Auth)
+		}
+		r.sources[source.Name] = &source
+	}
+	return r, nil
+}
+
+// Источники из JSON-файла path и списка list в формате name или name=callback_url (без авторизации).
+// Ключи источников из файла берутся из переменных окружения secret_env, описание в файле имеет приоритет над списком.
+// callbacks - адреса источников, у которых адрес не задан ни в файле, ни в списке (устаревшие переменные окружения)
+func Load(path string, list []string, callbacks map[string]string) (Registry, error) {
+	sources := make([]Source, 0)
+	declared := make(map[string]bool)
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("sources.Load: %w", err)
+		}
+		err = json.Unmarshal(data, &sources)
+		if err != nil {
+			return nil, fmt.Errorf("sources.Load: %w", err)
+		}
+		for i := range sources {
+			if sources[i].SecretEnv != "" {
+				sources[i].Secret = os.Getenv(sources[i].SecretEnv)
+			}
+			declared[sources[i].Name] = true
+		}
+	}
+	for _, item := range list {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		name, uri := parts[0], ""
+		if len(parts) == 2 {
+			uri = parts[1]
+		}
+		if declared[name] {
+			continue
+		}
+		declared[name] = true
+		sources = append(sources, Source{Name: name, CallbackURL: uri, Auth: AuthNone, Enabled: true})
+	}
+	for i := range sources {
+		if sources[i].CallbackURL == "" {
+			sources[i].CallbackURL = callbacks[sources[i].Name]
+		}
+	}
+	registry, err := NewRegistry(sources)
+	if err != nil {
+		return nil, fmt.Errorf("sources.Load: %w", err)
+	}
+	return registry, nil
+}
+
+func (r *registry) Get(name string) (*Source, bool) {
+	source, ok := r.sources[name]
+	return source, ok
+}
+
+func (r *registry) Check(name string, messageType model.RequestType) (*Source, error) {
+	source, ok := r.sources[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownSource, name)
+	}
+	if !source.Enabled {
+		return source, fmt.Errorf("%w: %q", ErrSourceDisabled, name)
+	}
+	if !source.Allows(messageType) {
+		return source, fmt.Errorf("%w: %q, %s", ErrMessageTypeNotAllowed, name, messageType)
+	}
+	return source, nil
+}
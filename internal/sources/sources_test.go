@@ -0,0 +1,136 @@
+package sources
+
+import (
+	"TController/internal/model"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewRegistry(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []Source
+		ok      bool
+	}{
+		{name: "defaults", sources: []Source{{Name: "sber", Enabled: true}}, ok: true},
+		{name: "hmac with secret", sources: []Source{{Name: "sber", Auth: AuthHMAC, Secret: "s"}}, ok: true},
+		{name: "empty name", sources: []Source{{}}},
+		{name: "declared twice", sources: []Source{{Name: "sber"}, {Name: "sber"}}},
+		{name: "hmac without secret", sources: []Source{{Name: "sber", Auth: AuthHMAC}}},
+		{name: "bearer without secret", sources: []Source{{Name: "sber", Auth: AuthBearer}}},
+		{name: "unknown auth", sources: []Source{{Name: "sber", Auth: "basic"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry, err := NewRegistry(tt.sources)
+			if (err == nil) != tt.ok {
+				t.Fatalf("err = %v", err)
+			}
+			if !tt.ok {
+				return
+			}
+			source, _ := registry.Get("sber")
+			if source.Auth == "" {
+				t.Fatalf("auth method is not set: %+v", source)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	registry, err := NewRegistry([]Source{
+		{Name: "sber", Enabled: true},
+		{Name: "vip", Enabled: true, MessageTypes: []model.RequestType{model.Create, Escalation}},
+		{Name: "old", Enabled: false},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		source      string
+		messageType model.RequestType
+		err         error
+	}{
+		{name: "all types allowed", source: "sber", messageType: model.Close},
+		{name: "allowed type", source: "vip", messageType: model.Create},
+		{name: "escalation filtered as type", source: "vip", messageType: Escalation},
+		{name: "type not allowed", source: "vip", messageType: model.Close, err: ErrMessageTypeNotAllowed},
+		{name: "disabled", source: "old", messageType: model.Create, err: ErrSourceDisabled},
+		{name: "unknown", source: "other", messageType: model.Create, err: ErrUnknownSource},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := registry.Check(tt.source, tt.messageType)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sources")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sources.json")
+	err = ioutil.WriteFile(path, []byte(`[
+		{"name":"sber","callback_url":"https://sber/events","auth":"hmac","secret_env":"TEST_SBER_SECRET","timeout":"5s"},
+		{"name":"old","enabled":false}
+	]`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("TEST_SBER_SECRET", "s3cret")
+	defer os.Unsetenv("TEST_SBER_SECRET")
+
+	registry, err := Load(path, []string{"sber=https://ignored", " crm=https://crm/events ", "", "plain", "sberapi"}, map[string]string{
+		"sberapi": "https://legacy/events",
+		"crm":     "https://legacy/crm",
+		"sber":    "https://legacy/sber",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		source  string
+		url     string
+		auth    AuthMethod
+		secret  string
+		enabled bool
+	}{
+		{name: "file has priority over list", source: "sber", url: "https://sber/events", auth: AuthHMAC, secret: "s3cret", enabled: true},
+		{name: "disabled in file", source: "old", auth: AuthNone},
+		{name: "list with callback", source: "crm", url: "https://crm/events", auth: AuthNone, enabled: true},
+		{name: "list without callback", source: "plain", auth: AuthNone, enabled: true},
+		{name: "legacy callback", source: "sberapi", url: "https://legacy/events", auth: AuthNone, enabled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, ok := registry.Get(tt.source)
+			if !ok {
+				t.Fatalf("source %q is not registered", tt.source)
+			}
+			if source.CallbackURL != tt.url || source.Auth != tt.auth || source.Secret != tt.secret || source.Enabled != tt.enabled {
+				t.Fatalf("source %+v", source)
+			}
+		})
+	}
+
+	_, err = Load(filepath.Join(dir, "missing.json"), nil, nil)
+	if err == nil {
+		t.Fatal("expected error for missing file")
+	}
+	//Ключ источника не задан в окружении
+	os.Unsetenv("TEST_SBER_SECRET")
+	_, err = Load(path, nil, nil)
+	if err == nil {
+		t.Fatal("expected error for hmac source without secret")
+	}
+}
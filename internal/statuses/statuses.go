@@ -0,0 +1,211 @@
+package statuses
+
+import (
+	"TController/internal/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrUnmapped = errors.New("status is not mapped")
+
+type Kind string
+
+const (
+	SystemToController Kind = "system"    //статус системы в статус контроллера
+	ControllerToSystem Kind = "to_system" //статус контроллера в статус системы
+	ControllerToSource Kind = "source"    //статус контроллера в статус источника
+)
+
+// Статусы системы. Если Canonical не задан, он строится обращением Native, когда это однозначно
+type SystemStatuses struct {
+	Native    map[string]model.TTStatus `json:"native"`              //статус системы -> статус контроллера
+	Canonical map[model.TTStatus]string `json:"canonical,omitempty"` //статус контроллера -> статус системы
+}
+
+// Системы задаются префиксом IDChannelOperatorForBilling ("KRUS", "RIAS_"), выбирается самый длинный.
+// Система или источник без таблицы используют статусы контроллера
+type Matrix struct {
+	Systems map[string]SystemStatuses            `json:"systems"`
+	Sources map[string]map[model.TTStatus]string `json:"sources"`
+}
+
+// Нераспознанное значение: Name - система или источник, Value - статус
+type Unmapped struct {
+	Kind     Kind   `json:"kind"`
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Count    int    `json:"count"`
+	LastSeen int64  `json:"last_seen"`
+}
+
+type Translator interface {
+	FromSystem(system, native string) (model.TTStatus, error)
+	ToSystem(system string, status model.TTStatus) (string, error)
+	ToSource(source string, status model.TTStatus) (string, error)
+	//Значения, не найденные в матрице с момента запуска
+	GetUnmapped() []Unmapped
+}
+
+type translator struct {
+	matrix   Matrix
+	mu       sync.Mutex
+	unmapped map[Unmapped]*Unmapped
+	now      func() time.Time
+}
+
+var canonical = map[model.TTStatus]bool{
+	model.Creating: true,
+	model.Error:    true,
+	model.Working:  true,
+	model.Waiting:  true,
+	model.Closed:   true,
+}
+
+func NewTranslator(matrix Matrix) (Translator, error) {
+	for system, statuses := range matrix.Systems {
+		for native, status := range statuses.Native {
+			if !canonical[status] {
+				return nil, fmt.Errorf("statuses.NewTranslator: system %q: %q maps to unknown status %q", system, native, status)
+			}
+		}
+		if len(statuses.Canonical) > 0 {
+			continue
+		}
+		inverse := make(map[model.TTStatus]string)
+		ambiguous := make(map[model.TTStatus]bool)
+		for native, status := range statuses.Native {
+			if _, ok := inverse[status]; ok {
+				ambiguous[status] = true
+			}
+			inverse[status] = native
+		}
+		for status := range ambiguous {
+			delete(inverse, status)
+		}
+		statuses.Canonical = inverse
+		matrix.Systems[system] = statuses
+	}
+	for source, vocabulary := range matrix.Sources {
+		for status := range vocabulary {
+			if !canonical[status] {
+				return nil, fmt.Errorf("statuses.NewTranslator: source %q: unknown status %q", source, status)
+			}
+		}
+	}
+	return &translator{matrix: matrix, unmapped: make(map[Unmapped]*Unmapped), now: time.Now}, nil
+}
+
+// Пустой path - матрица не задана, используются статусы контроллера
+func LoadMatrix(path string) (Translator, error) {
+	var matrix Matrix
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("statuses.LoadMatrix: %w", err)
+		}
+		err = json.Unmarshal(data, &matrix)
+		if err != nil {
+			return nil, fmt.Errorf("statuses.LoadMatrix: %w", err)
+		}
+	}
+	translator, err := NewTranslator(matrix)
+	if err != nil {
+		return nil, fmt.Errorf("statuses.LoadMatrix: %w", err)
+	}
+	return translator, nil
+}
+
+func (t *translator) system(system string) (SystemStatuses, bool) {
+	prefix := ""
+	for p := range t.matrix.Systems {
+		if strings.HasPrefix(system, p) && len(p) > len(prefix) {
+			prefix = p
+		}
+	}
+	statuses, ok := t.matrix.Systems[prefix]
+	return statuses, ok
+}
+
+func (t *translator) FromSystem(system, native string) (model.TTStatus, error) {
+	statuses, ok := t.system(system)
+	if !ok {
+		if canonical[model.TTStatus(native)] {
+			return model.TTStatus(native), nil
+		}
+		return "", t.report(SystemToController, system, native)
+	}
+	status, ok := statuses.Native[native]
+	if !ok {
+		return "", t.report(SystemToController, system, native)
+	}
+	return status, nil
+}
+
+func (t *translator) ToSystem(system string, status model.TTStatus) (string, error) {
+	statuses, ok := t.system(system)
+	if !ok {
+		if canonical[status] {
+			return string(status), nil
+		}
+		return "", t.report(ControllerToSystem, system, string(status))
+	}
+	native, ok := statuses.Canonical[status]
+	if !ok {
+		return "", t.report(ControllerToSystem, system, string(status))
+	}
+	return native, nil
+}
+
+func (t *translator) ToSource(source string, status model.TTStatus) (string, error) {
+	vocabulary, ok := t.matrix.Sources[source]
+	if !ok {
+		if canonical[status] {
+			return string(status), nil
+		}
+		return "", t.report(ControllerToSource, source, string(status))
+	}
+	value, ok := vocabulary[status]
+	if !ok {
+		return "", t.report(ControllerToSource, source, string(status))
+	}
+	return value, nil
+}
+
+func (t *translator) report(kind Kind, name, value string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := Unmapped{Kind: kind, Name: name, Value: value}
+	unmapped, ok := t.unmapped[key]
+	if !ok {
+		unmapped = &Unmapped{Kind: kind, Name: name, Value: value}
+		t.unmapped[key] = unmapped
+	}
+	unmapped.Count++
+	unmapped.LastSeen = t.now().Unix()
+	return fmt.Errorf("%w: %s %q, %q", ErrUnmapped, kind, name, value)
+}
+
+func (t *translator) GetUnmapped() []Unmapped {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	list := make([]Unmapped, 0, len(t.unmapped))
+	for _, unmapped := range t.unmapped {
+		list = append(list, *unmapped)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Kind != list[j].Kind {
+			return list[i].Kind < list[j].Kind
+		}
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].Value < list[j].Value
+	})
+	return list
+}
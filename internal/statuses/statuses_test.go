@@ -0,0 +1,161 @@
+package statuses
+
+import (
+	"TController/internal/model"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testMatrix() Matrix {
+	return Matrix{
+		Systems: map[string]SystemStatuses{
+			"RIAS_": {Native: map[string]model.TTStatus{
+				"new":      model.Creating,
+				"in work":  model.Working,
+				"accepted": model.Working,
+				"closed":   model.Closed,
+			}},
+			"RIAS_12": {Native: map[string]model.TTStatus{
+				"open":   model.Working,
+				"reject": model.Error,
+			}},
+			"KRUS": {
+				Native:    map[string]model.TTStatus{"1": model.Working, "2": model.Closed},
+				Canonical: map[model.TTStatus]string{model.Working: "1"},
+			},
+		},
+		Sources: map[string]map[model.TTStatus]string{
+			"sber": {model.Working: "IN_PROGRESS", model.Closed: "DONE"},
+		},
+	}
+}
+
+func TestFromSystem(t *testing.T) {
+	translator, err := NewTranslator(testMatrix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		system string
+		native string
+		want   model.TTStatus
+		err    error
+	}{
+		{name: "prefix", system: "RIAS_01", native: "in work", want: model.Working},
+		{name: "longest prefix", system: "RIAS_12", native: "reject", want: model.Error},
+		{name: "longest prefix only", system: "RIAS_12", native: "in work", err: ErrUnmapped},
+		{name: "exact name", system: "KRUS", native: "2", want: model.Closed},
+		{name: "unmapped native", system: "KRUS", native: "9", err: ErrUnmapped},
+		{name: "system without table", system: "OTHER", native: "working", want: model.Working},
+		{name: "system without table, unknown status", system: "OTHER", native: "in work", err: ErrUnmapped},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := translator.FromSystem(tt.system, tt.native)
+			if !errors.Is(err, tt.err) || got != tt.want {
+				t.Fatalf("FromSystem = %q, %v, want %q, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestToSystem(t *testing.T) {
+	translator, err := NewTranslator(testMatrix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		system string
+		status model.TTStatus
+		want   string
+		err    error
+	}{
+		{name: "inverse", system: "RIAS_01", status: model.Closed, want: "closed"},
+		{name: "ambiguous inverse", system: "RIAS_01", status: model.Working, err: ErrUnmapped},
+		{name: "explicit canonical", system: "KRUS", status: model.Working, want: "1"},
+		{name: "missing in explicit canonical", system: "KRUS", status: model.Closed, err: ErrUnmapped},
+		{name: "system without table", system: "OTHER", status: model.Waiting, want: "waiting"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := translator.ToSystem(tt.system, tt.status)
+			if !errors.Is(err, tt.err) || got != tt.want {
+				t.Fatalf("ToSystem = %q, %v, want %q, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestToSource(t *testing.T) {
+	translator, err := NewTranslator(testMatrix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		source string
+		status model.TTStatus
+		want   string
+		err    error
+	}{
+		{name: "vocabulary", source: "sber", status: model.Working, want: "IN_PROGRESS"},
+		{name: "missing in vocabulary", source: "sber", status: model.Waiting, err: ErrUnmapped},
+		{name: "source without vocabulary", source: "api", status: model.Closed, want: "closed"},
+		{name: "unknown status", source: "api", status: "done", err: ErrUnmapped},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := translator.ToSource(tt.source, tt.status)
+			if !errors.Is(err, tt.err) || got != tt.want {
+				t.Fatalf("ToSource = %q, %v, want %q, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestGetUnmapped(t *testing.T) {
+	tr, err := NewTranslator(testMatrix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	tr.(*translator).now = func() time.Time { return now }
+	_, _ = tr.FromSystem("KRUS", "9")
+	_, _ = tr.ToSource("sber", model.Waiting)
+	now = now.Add(time.Minute)
+	_, _ = tr.FromSystem("KRUS", "9")
+	_, _ = tr.FromSystem("RIAS_01", "in work")
+	want := []Unmapped{
+		{Kind: ControllerToSource, Name: "sber", Value: "waiting", Count: 1, LastSeen: 1700000000},
+		{Kind: SystemToController, Name: "KRUS", Value: "9", Count: 2, LastSeen: 1700000060},
+	}
+	got := tr.GetUnmapped()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unmapped %+v\nwant     %+v", got, want)
+	}
+}
+
+func TestNewTranslator(t *testing.T) {
+	tests := []struct {
+		name   string
+		matrix Matrix
+		ok     bool
+	}{
+		{name: "empty", ok: true},
+		{name: "valid", matrix: testMatrix(), ok: true},
+		{name: "unknown system status", matrix: Matrix{Systems: map[string]SystemStatuses{"KRUS": {Native: map[string]model.TTStatus{"1": "done"}}}}},
+		{name: "unknown source status", matrix: Matrix{Sources: map[string]map[model.TTStatus]string{"sber": {"done": "DONE"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewTranslator(tt.matrix)
+			if (err == nil) != tt.ok {
+				t.Fatalf("err = %v", err)
+			}
+		})
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"TController/internal/model"
 	"context"
 	"fmt"
-	"regexp"
 )
 
 type ticketWorker struct {
@@ -65,20 +64,3 @@ func (t *ticketWorker) CloseTicket(ctx context.Context, ticket *model.Ticket) (e
 	}
 	return nil
 }
-
-func (t *ticketWorker) IDChannelConverter(idChannelOperator string) (idChannelOperatorForBilling string, err error) {
-	var re = regexp.MustCompile(`(^[a-zA-Z]{3,4})(\d+)-(.+)`)
-	parts := re.FindStringSubmatch(idChannelOperator)
-	if parts == nil {
-		return idChannelOperatorForBilling, fmt.Errorf("IDChannelConverter: wrong IDChannelOperator")
-	}
-	if (len(parts[1]) == 4) && (len(parts[2]) == 2) {
-		idChannelOperatorForBilling = fmt.Sprintf("RIAS_%s", parts[2])
-		return idChannelOperatorForBilling, nil
-	}
-	if (len(parts[1]) == 3) && (len(parts[2]) == 4) {
-		idChannelOperatorForBilling = "KRUS"
-		return idChannelOperatorForBilling, nil
-	}
-	return idChannelOperatorForBilling, fmt.Errorf("IDChannelConverter: wrong IDChannelOperator")
-}
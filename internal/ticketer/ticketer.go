@@ -12,5 +12,4 @@ type Ticket interface {
 	CheckTicketStatus(ctx context.Context, ticket *model.Ticket) error
 	AddNoteToTicket(ctx context.Context, ticket *model.Ticket) error
 	CloseTicket(ctx context.Context, ticket *model.Ticket) error
-	IDChannelConverter(idChannelOperator string) (string, error)
 }
@@ -2,53 +2,177 @@ package timer
 
 import (
 	"TController/internal/cache"
+	"TController/internal/messageBroker"
 	"TController/internal/model"
+	"TController/internal/responseController"
+	"TController/internal/sla"
 	"context"
+	"encoding/json"
 	"fmt"
-	"log"
-	"strconv"
 	"time"
 
 	"go.uber.org/zap"
 )
 
 type timer struct {
-	ctx   context.Context
-	TTL   time.Duration
-	cache cache.Cache
-	lg    *zap.Logger
+	ctx             context.Context
+	interval        time.Duration
+	rerouteTimeout  time.Duration
+	policies        sla.Policies
+	cache           cache.Cache
+	receiver        responseController.Response
+	broker          messageBroker.Broker
+	escalationTopic string
+	lg              *zap.Logger
 }
 
-func NewTimer(ctx context.Context, TTL time.Duration, cache cache.Cache) *timer {
-	return &timer{ctx: ctx, TTL: TTL, cache: cache}
+func NewTimer(ctx context.Context,
+	interval time.Duration,
+	rerouteTimeout time.Duration,
+	policies sla.Policies,
+	cache cache.Cache,
+	receiver responseController.Response,
+	broker messageBroker.Broker,
+	escalationTopic string,
+	lg *zap.Logger) *timer {
+	return &timer{
+		ctx:             ctx,
+		interval:        interval,
+		rerouteTimeout:  rerouteTimeout,
+		policies:        policies,
+		cache:           cache,
+		receiver:        receiver,
+		broker:          broker,
+		escalationTopic: escalationTopic,
+		lg:              lg,
+	}
 }
 
-func (t *timer) FindExpired() (*[]cache.CacheRecord, error) {
-	expiredRecords := make([]cache.CacheRecord, 0)
-	keys, err := t.cache.GetAllKeysFromCache(t.ctx)
+// Проверка кэша раз в interval до отмены контекста
+func (t *timer) Run() {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.ctx.Done():
+			return
+		case <-ticker.C:
+			err := t.CheckExpired()
+			if err != nil {
+				t.lg.Error("timer.Run", zap.Error(err))
+				continue
+			}
+			t.lg.Info("Cache checked for expired records")
+		}
+	}
+}
 
+// Проверка всех записей кэша на нарушение сроков SLA, по каждому сроку источник уведомляется один раз.
+// Если система не ответила за rerouteTimeout, запрос переводится в следующую систему из списка кандидатов
+// независимо от политики SLA, если кандидатов не осталось - уведомляем источник
+func (t *timer) CheckExpired() error {
+	keys, err := t.cache.GetAllKeysFromCache(t.ctx)
 	if err != nil {
-		return &expiredRecords, fmt.Errorf("timer.findExpired: %w", err)
+		return fmt.Errorf("timer.CheckExpired: %w", err)
 	}
+	timeNow := time.Now()
 	for _, k := range keys {
 		record, err := t.cache.GetFromCacheByKey(t.ctx, k)
-		log.Printf("checking key %v", record)
 		if err != nil {
-			return &expiredRecords, fmt.Errorf("timer.findExpired: %w", err)
+			return fmt.Errorf("timer.CheckExpired: %w", err)
+		}
+		if record.TicketID == "" {
+			continue
+		}
+		breaches, err := t.policies.Breaches(record, timeNow)
+		if err != nil {
+			t.lg.Error("timer.CheckExpired", zap.String("key", k), zap.Error(err))
+		}
+		for _, breach := range breaches {
+			t.escalate(record, breach, timeNow)
+			record.AddEscalation(string(breach.Deadline))
+		}
+		if len(breaches) > 0 {
+			err = t.cache.UpdateCache(t.ctx, &cache.CacheRecord{
+				TicketID:    record.TicketID,
+				Escalations: record.Escalations,
+				//Уведомление не является ответом системы и не сдвигает срок перевода
+				Modified: record.Modified,
+			})
+			if err != nil {
+				t.lg.Error("timer.CheckExpired", zap.Error(err))
+			}
+		}
+		expired, err := t.expired(record, timeNow)
+		if err != nil {
+			t.lg.Error("timer.CheckExpired", zap.String("key", k), zap.Error(err))
+			continue
 		}
-		if (record.Status == model.Creating) || (record.Status == model.Error) {
-			log.Printf("found right ticket %v", keys)
-			timeNow := time.Now()
-			i, err := strconv.ParseInt(record.Modified, 10, 64)
+		if expired {
+			t.lg.Info("timer: rerouting expired ticket", zap.String("ticket_id", record.TicketID))
+			err = t.receiver.ReRouteTicket(t.ctx, record)
 			if err != nil {
-				return &expiredRecords, fmt.Errorf("timer.findExpired: %w", err)
+				t.lg.Error("timer.CheckExpired", zap.String("ticket_id", record.TicketID), zap.Error(err))
 			}
-			modifiedTime := time.Unix(i, 0)
-			log.Printf("time: %v - %v", timeNow, modifiedTime.Add(time.Minute*30))
-			if timeNow.After(modifiedTime.Add(time.Minute * 30)) {
-				log.Printf("succesful time comparison %s", record.Modified)
+		}
+	}
+	return nil
+}
+
+// Система не ответила на create за rerouteTimeout с последней отправки, 0 - не переводить
+func (t *timer) expired(record *cache.CacheRecord, timeNow time.Time) (bool, error) {
+	if t.rerouteTimeout <= 0 {
+		return false, nil
+	}
+	if record.Status != model.Creating && record.Status != model.Error {
+		return false, nil
+	}
+	modified, err := cache.ParseTimestamp(record.Modified)
+	if err != nil {
+		return false, err
+	}
+	return timeNow.After(modified.Add(t.rerouteTimeout)), nil
+}
+
+func (t *timer) escalate(record *cache.CacheRecord, breach sla.Breach, timeNow time.Time) {
+	escalation := model.Escalation{
+		TicketID:                    record.TicketID,
+		Source:                      record.Source,
+		CustomerInternalID:          record.CustomerInternalID,
+		IDChannelOperatorForBilling: record.IDChannelOperatorForBilling,
+		IDChannelOperator:           record.IDChannelOperator,
+		TTClassification:            record.TTClassification,
+		OperatorTTId:                record.OperatorTTId,
+		Status:                      string(record.Status),
+		Policy:                      breach.Policy.Name,
+		Deadline:                    string(breach.Deadline),
+		DueTimeTS:                   breach.Due.Unix(),
+		EventTimeTS:                 timeNow.Unix(),
+	}
+	t.lg.Info("timer: SLA breach",
+		zap.String("ticket_id", record.TicketID),
+		zap.String("deadline", escalation.Deadline),
+		zap.String("policy", escalation.Policy))
+	//Событие создает контроллер, сквозным идентификатором служит TicketID
+	ctx := model.WithSource(model.WithCorrelationID(t.ctx, record.TicketID), record.Source)
+	if t.escalationTopic != "" {
+		value, err := json.Marshal(escalation)
+		if err != nil {
+			t.lg.Error("timer.escalate", zap.Error(err))
+			return
+		}
+		ticketID := record.TicketID
+		err = t.broker.PushEventAsync(ctx, t.escalationTopic, escalation.Key(), value, func(err error) {
+			if err != nil {
+				t.lg.Error("timer.escalate", zap.String("ticket_id", ticketID), zap.Error(err))
 			}
+		})
+		if err != nil {
+			t.lg.Error("timer.escalate", zap.Error(err))
 		}
 	}
-	return &expiredRecords, nil
+	err := t.receiver.SendEscalation(ctx, &escalation)
+	if err != nil {
+		t.lg.Error("timer.escalate", zap.Error(err))
+	}
 }
@@ -0,0 +1,99 @@
+package timer
+
+import (
+	"TController/internal/cache"
+	"TController/internal/model"
+	"TController/internal/sla"
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type fakeReceiver struct {
+	rerouted    []string
+	escalations []string
+}
+
+func (f *fakeReceiver) InitReceiversPull(n int)                          {}
+func (f *fakeReceiver) ResponseReceiver(out chan *model.Message, id int) {}
+
+func (f *fakeReceiver) ReRouteTicket(ctx context.Context, record *cache.CacheRecord) error {
+	f.rerouted = append(f.rerouted, record.TicketID)
+	return nil
+}
+
+func (f *fakeReceiver) DeclineTicket(ctx context.Context, record *cache.CacheRecord) error {
+	return nil
+}
+
+func (f *fakeReceiver) SendEscalation(ctx context.Context, escalation *model.Escalation) error {
+	f.escalations = append(f.escalations, escalation.TicketID+":"+escalation.Deadline)
+	return nil
+}
+
+func TestCheckExpired(t *testing.T) {
+	now := time.Now()
+	ago := func(d time.Duration) string { return cache.Timestamp(now.Add(-d)) }
+	tests := []struct {
+		name        string
+		policy      sla.Policy
+		record      cache.CacheRecord
+		rerouted    int
+		escalations int
+	}{
+		{
+			name:     "reroute without acknowledgement policy",
+			policy:   sla.Policy{Name: "default"},
+			record:   cache.CacheRecord{TicketID: "T1", Status: model.Creating, Created: ago(time.Hour), Modified: ago(time.Hour)},
+			rerouted: 2,
+		},
+		{
+			name:        "acknowledgement breach notified once",
+			policy:      sla.Policy{Name: "default", Acknowledgement: model.Duration(10 * time.Minute)},
+			record:      cache.CacheRecord{TicketID: "T1", Status: model.Error, Created: ago(time.Hour), Modified: ago(time.Minute)},
+			escalations: 1,
+		},
+		{
+			name:        "breach and reroute",
+			policy:      sla.Policy{Name: "default", Acknowledgement: model.Duration(10 * time.Minute)},
+			record:      cache.CacheRecord{TicketID: "T1", Status: model.Creating, Created: ago(time.Hour), Modified: ago(time.Hour)},
+			rerouted:    2,
+			escalations: 1,
+		},
+		{
+			name:   "answered ticket",
+			policy: sla.Policy{Name: "default"},
+			record: cache.CacheRecord{TicketID: "T1", Status: model.Working, Created: ago(time.Hour), Modified: ago(time.Hour)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cache.NewMemoryCache(3600, zap.NewNop())
+			err := c.WriteToCache(context.Background(), &tt.record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			receiver := &fakeReceiver{}
+			timer := NewTimer(context.Background(), time.Minute, 30*time.Minute, sla.NewPolicies(nil, tt.policy), c, receiver, nil, "", zap.NewNop())
+			//Вторая проверка не должна повторять уведомления; перевод выполняет fakeReceiver, запись не меняется
+			for i := 0; i < 2; i++ {
+				err = timer.CheckExpired()
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(receiver.rerouted) != tt.rerouted {
+				t.Errorf("rerouted %d times, want %d", len(receiver.rerouted), tt.rerouted)
+			}
+			if len(receiver.escalations) != tt.escalations {
+				t.Errorf("escalations %v, want %d", receiver.escalations, tt.escalations)
+			}
+			stored, _ := c.GetFromCacheByTicketID(context.Background(), "T1")
+			if stored.Modified != tt.record.Modified {
+				t.Errorf("modified changed from %s to %s", tt.record.Modified, stored.Modified)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+// Package webhook - подпись событий, которые контроллер отправляет в источники, и ее проверка на стороне источника.
+//
+// Подписывается строка "<timestamp>.<nonce>.<тело запроса>" ключом источника (HMAC-SHA256),
+// подпись передается в заголовке X-TController-Signature в виде "sha256=<hex>".
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderSignature = "X-TController-Signature"
+	HeaderTimestamp = "X-TController-Timestamp"
+	HeaderNonce     = "X-TController-Nonce"
+	//Не входит в подпись, связывает событие с запросом источника и ответом системы
+	HeaderCorrelationID = "X-Correlation-ID"
+
+	signaturePrefix = "sha256="
+)
+
+var ErrHeaderMissing = errors.New("webhook signature headers are missing")
+var ErrSignatureMismatch = errors.New("webhook signature mismatch")
+var ErrTimestampExpired = errors.New("webhook timestamp is outside the allowed window")
+var ErrReplay = errors.New("webhook nonce has already been used")
+
+// Подпись тела body, timestamp - unix-время в секундах
+func Sign(secret []byte, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write([]byte(nonce))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Устанавливает заголовки подписи запроса с новым nonce, body - тело запроса
+func SignRequest(request *http.Request, secret []byte, body []byte, now time.Time) error {
+	nonce, err := newNonce()
+	if err != nil {
+		return fmt.Errorf("webhook.SignRequest: %w", err)
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	request.Header.Set(HeaderTimestamp, timestamp)
+	request.Header.Set(HeaderNonce, nonce)
+	request.Header.Set(HeaderSignature, Sign(secret, timestamp, nonce, body))
+	return nil
+}
+
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Хранилище использованных nonce. Seen запоминает nonce до expires и возвращает true, если он уже встречался
+type NonceStore interface {
+	Seen(nonce string, expires time.Time) bool
+}
+
+// Проверка входящих событий контроллера на стороне источника
+type Verifier struct {
+	secret    []byte
+	tolerance time.Duration
+	nonces    NonceStore
+	now       func() time.Time
+}
+
+// tolerance - допустимое расхождение времени подписи и времени проверки, в пределах него nonce не может повториться.
+// nonces == nil - nonce хранятся в памяти процесса
+func NewVerifier(secret []byte, tolerance time.Duration, nonces NonceStore) *Verifier {
+	if nonces == nil {
+		nonces = NewMemoryNonceStore()
+	}
+	return &Verifier{secret: secret, tolerance: tolerance, nonces: nonces, now: time.Now}
+}
+
+func (v *Verifier) Verify(header http.Header, body []byte) error {
+	signature := header.Get(HeaderSignature)
+	timestamp := header.Get(HeaderTimestamp)
+	nonce := header.Get(HeaderNonce)
+	if signature == "" || timestamp == "" || nonce == "" {
+		return ErrHeaderMissing
+	}
+	expected := Sign(v.secret, timestamp, nonce, body)
+	if !hmac.Equal([]byte(strings.ToLower(signature)), []byte(expected)) {
+		return ErrSignatureMismatch
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrSignatureMismatch
+	}
+	signed := time.Unix(ts, 0)
+	now := v.now()
+	if signed.Before(now.Add(-v.tolerance)) || signed.After(now.Add(v.tolerance)) {
+		return ErrTimestampExpired
+	}
+	//Nonce проверяется последним, чтобы запрос с неверной подписью не занимал его
+	if v.nonces.Seen(nonce, signed.Add(v.tolerance)) {
+		return ErrReplay
+	}
+	return nil
+}
+
+// Читает тело запроса, проверяет подпись и возвращает тело для дальнейшей обработки
+func (v *Verifier) VerifyRequest(request *http.Request, maxBodySize int64) ([]byte, error) {
+	body, err := readAll(request, maxBodySize)
+	if err != nil {
+		return nil, err
+	}
+	err = v.Verify(request.Header, body)
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+type memoryNonceStore struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+	now    func() time.Time
+}
+
+func NewMemoryNonceStore() NonceStore {
+	return &memoryNonceStore{nonces: make(map[string]time.Time), now: time.Now}
+}
+
+func (m *memoryNonceStore) Seen(nonce string, expires time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	for n, e := range m.nonces {
+		if !now.Before(e) {
+			delete(m.nonces, n)
+		}
+	}
+	if _, ok := m.nonces[nonce]; ok {
+		return true
+	}
+	m.nonces[nonce] = expires
+	return false
+}
+
+// maxBodySize <= 0 - без ограничения
+func readAll(request *http.Request, maxBodySize int64) ([]byte, error) {
+	if request.Body == nil {
+		return []byte{}, nil
+	}
+	reader := request.Body
+	if maxBodySize > 0 {
+		reader = http.MaxBytesReader(nil, request.Body, maxBodySize)
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
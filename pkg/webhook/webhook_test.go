@@ -0,0 +1,99 @@
+package webhook
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"ticket_id":"A","status":"working"}`)
+	signed := time.Unix(1700000000, 0)
+	tests := []struct {
+		name   string
+		secret []byte
+		body   []byte
+		change func(header http.Header)
+		now    time.Time
+		err    error
+	}{
+		{name: "valid", now: signed},
+		{name: "upper case signature", now: signed, change: func(h http.Header) {
+			h.Set(HeaderSignature, signaturePrefix+strings.ToUpper(strings.TrimPrefix(h.Get(HeaderSignature), signaturePrefix)))
+		}},
+		{name: "tampered body", body: []byte(`{"ticket_id":"A","status":"closed"}`), now: signed, err: ErrSignatureMismatch},
+		{name: "tampered timestamp", now: signed, err: ErrSignatureMismatch, change: func(h http.Header) {
+			h.Set(HeaderTimestamp, "1700000001")
+		}},
+		{name: "tampered nonce", now: signed, err: ErrSignatureMismatch, change: func(h http.Header) {
+			h.Set(HeaderNonce, "0000")
+		}},
+		{name: "tampered signature", now: signed, err: ErrSignatureMismatch, change: func(h http.Header) {
+			h.Set(HeaderSignature, signaturePrefix+strings.Repeat("0", 64))
+		}},
+		{name: "other secret", secret: []byte("other"), now: signed, err: ErrSignatureMismatch},
+		{name: "missing signature", now: signed, err: ErrHeaderMissing, change: func(h http.Header) {
+			h.Del(HeaderSignature)
+		}},
+		{name: "too old", now: signed.Add(6 * time.Minute), err: ErrTimestampExpired},
+		{name: "from the future", now: signed.Add(-6 * time.Minute), err: ErrTimestampExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, _ := http.NewRequest(http.MethodPost, "http://source/events", nil)
+			err := SignRequest(request, secret, body, signed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.change != nil {
+				tt.change(request.Header)
+			}
+			verifySecret, verifyBody := secret, body
+			if tt.secret != nil {
+				verifySecret = tt.secret
+			}
+			if tt.body != nil {
+				verifyBody = tt.body
+			}
+			verifier := NewVerifier(verifySecret, 5*time.Minute, nil)
+			verifier.now = func() time.Time { return tt.now }
+			err = verifier.Verify(request.Header, verifyBody)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestVerifyRequestReplay(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"ticket_id":"A"}`)
+	request, _ := http.NewRequest(http.MethodPost, "http://source/events", bytes.NewReader(body))
+	err := SignRequest(request, secret, body, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := NewVerifier(secret, time.Minute, nil)
+	got, err := verifier.VerifyRequest(request, 1024)
+	if err != nil || !bytes.Equal(got, body) {
+		t.Fatalf("body = %s, err = %v", got, err)
+	}
+	//Тот же запрос повторно, с теми же заголовками и nonce
+	replayed, _ := http.NewRequest(http.MethodPost, "http://source/events", bytes.NewReader(body))
+	replayed.Header = request.Header.Clone()
+	_, err = verifier.VerifyRequest(replayed, 1024)
+	if !errors.Is(err, ErrReplay) {
+		t.Fatalf("err = %v, want %v", err, ErrReplay)
+	}
+	//Тело больше ограничения не читается целиком
+	large, _ := http.NewRequest(http.MethodPost, "http://source/events", bytes.NewReader(body))
+	large.Header = request.Header.Clone()
+	_, err = verifier.VerifyRequest(large, 4)
+	if err == nil {
+		t.Fatal("expected body size error")
+	}
+}